@@ -0,0 +1,203 @@
+// Package client is a Go client for the Log service. Client wraps the
+// service's RPCs with deadlines and retries, Producer batches records
+// and produces them asynchronously, and Consumer reads a partition
+// from an offset onwards, keeping track of its position.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	// Address of the server, as host:port.
+	Addr string
+	// TLS config used to connect to the server.
+	// Connections are insecure when nil.
+	TLS *tls.Config
+	// Additional options used to dial the server.
+	DialOptions []grpc.DialOption
+	// Deadline of each attempt at a request, on top of any deadline
+	// the request's context has. Defaults to 10 seconds.
+	Timeout time.Duration
+	Retry   struct {
+		// Number of times a request is retried after failing with a
+		// retryable error. Defaults to 5, negative disables retries.
+		MaxRetries int
+		// Backoff before the first retry, which doubles after each
+		// one up to MaxBackoff. Defaults to 100ms and 5 seconds.
+		MinBackoff time.Duration
+		MaxBackoff time.Duration
+	}
+}
+
+// Client is a connection to a Log server. The connection is made lazily
+// and reestablished whenever it's lost, requests failing in between are
+// retried. It's safe for concurrent use.
+type Client struct {
+	Config Config
+
+	conn *grpc.ClientConn
+	api  api.LogClient
+}
+
+// New returns a client for the server at config.Addr.
+func New(config Config) (*Client, error) {
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Retry.MaxRetries == 0 {
+		config.Retry.MaxRetries = 5
+	}
+	if config.Retry.MinBackoff == 0 {
+		config.Retry.MinBackoff = 100 * time.Millisecond
+	}
+	if config.Retry.MaxBackoff == 0 {
+		config.Retry.MaxBackoff = 5 * time.Second
+	}
+
+	creds := insecure.NewCredentials()
+	if config.TLS != nil {
+		creds = credentials.NewTLS(config.TLS)
+	}
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, config.DialOptions...)
+
+	conn, err := grpc.NewClient(config.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Config: config,
+		conn:   conn,
+		api:    api.NewLogClient(conn),
+	}, nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// API returns the service's generated client, for RPCs the client doesn't
+// wrap. Its requests aren't given deadlines or retried.
+func (c *Client) API() api.LogClient {
+	return c.api
+}
+
+// Produce appends the request's record. Requests from idempotent producers,
+// with a producer ID and sequence, are retried. Others aren't, since a
+// request that failed may still have been appended and a retry would append
+// it twice, use a Producer to have them retried without duplicates.
+func (c *Client) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	var res *api.ProduceResponse
+	err := c.call(ctx, req.ProducerId != 0, func(ctx context.Context) (err error) {
+		res, err = c.api.Produce(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// Consume reads the record at the request's offset.
+func (c *Client) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	var res *api.ConsumeResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.api.Consume(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// ConsumeBatch reads a batch of records from the request's offset.
+func (c *Client) ConsumeBatch(ctx context.Context, req *api.ConsumeBatchRequest) (*api.ConsumeBatchResponse, error) {
+	var res *api.ConsumeBatchResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.api.ConsumeBatch(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// InitProducerID returns a new producer ID for an idempotent producer.
+func (c *Client) InitProducerID(ctx context.Context) (uint64, error) {
+	var res *api.InitProducerIdResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.api.InitProducerId(ctx, &api.InitProducerIdRequest{})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.ProducerId, nil
+}
+
+// ListTopics returns every topic on the server.
+func (c *Client) ListTopics(ctx context.Context) ([]*api.Topic, error) {
+	var res *api.ListTopicsResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.api.ListTopics(ctx, &api.ListTopicsRequest{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Topics, nil
+}
+
+// ListOffsets returns the first offset of a topic's partition that can be
+// consumed, the offset its next record will be appended at and its last
+// stable offset.
+func (c *Client) ListOffsets(ctx context.Context, topic string, partition uint32) (*api.ListOffsetsResponse, error) {
+	var res *api.ListOffsetsResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.api.ListOffsets(ctx, &api.ListOffsetsRequest{Topic: topic, Partition: partition})
+		return err
+	})
+	return res, err
+}
+
+// call makes a request with fn, giving each attempt the client's timeout.
+// Failed attempts are retried with backoff when retry is set and the error
+// is retryable, until the retries run out or ctx is done.
+func (c *Client) call(ctx context.Context, retry bool, fn func(ctx context.Context) error) error {
+	backoff := c.Config.Retry.MinBackoff
+	for attempt := 0; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, c.Config.Timeout)
+		err := fn(actx)
+		cancel()
+
+		if err == nil || !retry || attempt >= c.Config.Retry.MaxRetries || ctx.Err() != nil || !Retryable(err) {
+			return err
+		}
+
+		// Jitter the backoff so clients failing together
+		// don't retry together.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff = min(backoff*2, c.Config.Retry.MaxBackoff)
+	}
+}
+
+// Retryable returns whether a request that failed with err may succeed
+// if it's retried, because the server was unreachable, overloaded or
+// didn't respond in time.
+func Retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,541 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/log"
+	"github.com/masonictemple4/proglog/internal/server"
+	"github.com/masonictemple4/proglog/internal/topic"
+	"github.com/masonictemple4/proglog/internal/txn"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClient(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, client *Client, srv *testServer){
+		"produce and consume records":                      testProduceConsume,
+		"producer batches records and reports offsets":     testProducer,
+		"producer retries without duplicating records":     testProducerRetries,
+		"producer pipelines requests to a partition":       testProducerPipelining,
+		"consumer resumes after the server restarts":       testConsumerResumes,
+		"consumer seeks to an offset":                      testConsumerSeek,
+		"requests time out at the context's deadline":      testDeadline,
+		"producer fails records for unknown topics":        testProducerUnknownTopic,
+		"producer picks up added partitions":               testProducerPartitionsAdded,
+		"non idempotent produce requests aren't retried":   testProduceNotRetried,
+		"producer flushes buffered records when it closes": testProducerClose,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			srv := newTestServer(t)
+			defer srv.remove()
+
+			// Reconnect quickly once a stopped server's started again.
+			client, err := New(Config{
+				Addr: srv.addr,
+				DialOptions: []grpc.DialOption{grpc.WithConnectParams(grpc.ConnectParams{
+					Backoff: backoff.Config{BaseDelay: 10 * time.Millisecond, Multiplier: 1.6, MaxDelay: 100 * time.Millisecond},
+				})},
+			})
+			require.NoError(t, err)
+			client.Config.Retry.MinBackoff = 10 * time.Millisecond
+			client.Config.Retry.MaxBackoff = 100 * time.Millisecond
+			client.Config.Retry.MaxRetries = 20
+			defer client.Close()
+
+			fn(t, client, srv)
+		})
+	}
+}
+
+func testProduceConsume(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	for i := uint64(0); i < 3; i++ {
+		res, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+		})
+		require.NoError(t, err)
+		require.Equal(t, i, res.Offset)
+	}
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, []byte("record 1"), consume.Record.Value)
+
+	batch, err := client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{})
+	require.NoError(t, err)
+	require.Len(t, batch.Records, 3)
+	require.Equal(t, uint64(3), batch.NextOffset)
+
+	offsets, err := client.ListOffsets(ctx, "", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), offsets.HighOffset)
+}
+
+func testProducer(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	_, err := client.API().CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{Partitions: 3},
+	})
+	require.NoError(t, err)
+
+	producer := client.NewProducer(ProducerConfig{BatchSize: 8})
+	defer producer.Close()
+
+	var (
+		mu        sync.Mutex
+		callbacks int
+	)
+	futures := make([]*Future, 30)
+	for i := range futures {
+		record := &api.Record{Value: []byte(fmt.Sprintf("record %d", i))}
+		if i%2 == 0 {
+			record.Key = []byte("user-1")
+		}
+		futures[i], err = producer.Send(ctx, &Message{Topic: "events", Record: record}, func(offset uint64, partition uint32, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				callbacks++
+			}
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, producer.Flush(ctx))
+	require.Equal(t, len(futures), callbacks)
+
+	// keyed records land on the partition the server would pick.
+	keyed, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("keyed"), Key: []byte("user-1")},
+		Topic:  "events",
+	})
+	require.NoError(t, err)
+
+	next := make(map[uint32]uint64)
+	for i, f := range futures {
+		offset, partition, err := f.Wait(ctx)
+		require.NoError(t, err)
+		if i%2 == 0 {
+			require.Equal(t, keyed.Partition, partition)
+		}
+
+		// each partition's records are appended in order.
+		require.Equal(t, next[partition], offset)
+		next[partition]++
+
+		consume, err := client.Consume(ctx, &api.ConsumeRequest{Topic: "events", Partition: partition, Offset: offset})
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("record %d", i)), consume.Record.Value)
+	}
+	// unkeyed records are spread over the partitions.
+	require.Len(t, next, 3)
+}
+
+func testProducerRetries(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	producer := client.NewProducer(ProducerConfig{})
+	defer producer.Close()
+
+	f, err := producer.Send(ctx, &Message{Record: &api.Record{Value: []byte("first")}}, nil)
+	require.NoError(t, err)
+	_, _, err = f.Wait(ctx)
+	require.NoError(t, err)
+
+	// records sent while the server's down are produced once it's back.
+	srv.stop()
+	futures := make([]*Future, 5)
+	for i := range futures {
+		futures[i], err = producer.Send(ctx, &Message{Record: &api.Record{Value: []byte("second")}}, nil)
+		require.NoError(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	srv.start()
+
+	for i, f := range futures {
+		offset, _, err := f.Wait(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i+1), offset)
+	}
+
+	batch, err := client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{})
+	require.NoError(t, err)
+	require.Len(t, batch.Records, 6)
+}
+
+func testProducerPipelining(t *testing.T, _ *Client, srv *testServer) {
+	ctx := context.Background()
+
+	// Delay every third sequence so the requests
+	// behind it reach the server first.
+	var (
+		mu                 sync.Mutex
+		inflight, observed int
+	)
+	delay := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		produce, ok := req.(*api.ProduceRequest)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		mu.Lock()
+		inflight++
+		observed = max(observed, inflight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+		}()
+
+		if produce.Sequence%3 == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	client, err := New(Config{
+		Addr:        srv.addr,
+		DialOptions: []grpc.DialOption{grpc.WithUnaryInterceptor(delay)},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	producer := client.NewProducer(ProducerConfig{})
+	defer producer.Close()
+
+	futures := make([]*Future, 20)
+	for i := range futures {
+		futures[i], err = producer.Send(ctx, &Message{Record: &api.Record{Value: []byte(fmt.Sprintf("record %d", i))}}, nil)
+		require.NoError(t, err)
+	}
+	require.NoError(t, producer.Flush(ctx))
+
+	// requests were in flight together, and still appended in order.
+	require.Equal(t, producerWindow, observed)
+	for i, f := range futures {
+		offset, _, err := f.Wait(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), offset)
+	}
+
+	batch, err := client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{})
+	require.NoError(t, err)
+	require.Len(t, batch.Records, len(futures))
+	for i, record := range batch.Records {
+		require.Equal(t, []byte(fmt.Sprintf("record %d", i)), record.Value)
+	}
+}
+
+func testConsumerResumes(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	produce(t, client, 3)
+
+	consumer := client.NewConsumer(ConsumerConfig{MaxRecords: 1, PollInterval: 10 * time.Millisecond})
+	for i := uint64(0); i < 2; i++ {
+		record, err := consumer.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, i, record.Offset)
+	}
+	require.Equal(t, uint64(2), consumer.Offset())
+
+	srv.stop()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		srv.start()
+	}()
+
+	record, err := consumer.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), record.Offset)
+
+	// caught up consumers wait for the next record.
+	produced := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		produced <- err
+	}()
+	record, err = consumer.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), record.Offset)
+	require.NoError(t, <-produced)
+}
+
+func testConsumerSeek(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	produce(t, client, 5)
+
+	consumer := client.NewConsumer(ConsumerConfig{Offset: 1})
+	record, err := consumer.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), record.Offset)
+
+	consumer.Seek(4)
+	record, err = consumer.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), record.Offset)
+	require.Equal(t, uint64(5), consumer.Offset())
+}
+
+func testDeadline(t *testing.T, client *Client, srv *testServer) {
+	srv.stop()
+	defer srv.start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	consumer := client.NewConsumer(ConsumerConfig{})
+	_, err := consumer.Next(ctx)
+	require.Error(t, err)
+	require.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	require.Equal(t, uint64(0), consumer.Offset())
+}
+
+func testProducerUnknownTopic(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	producer := client.NewProducer(ProducerConfig{})
+	defer producer.Close()
+
+	f, err := producer.Send(ctx, &Message{Topic: "missing", Record: &api.Record{Value: []byte("hello")}}, nil)
+	require.NoError(t, err)
+	_, _, err = f.Wait(ctx)
+	require.ErrorIs(t, err, ErrTopicNotFound)
+
+	// later records aren't held up by the failure.
+	f, err = producer.Send(ctx, &Message{Record: &api.Record{Value: []byte("hello")}}, nil)
+	require.NoError(t, err)
+	_, _, err = f.Wait(ctx)
+	require.NoError(t, err)
+}
+
+func testProducerPartitionsAdded(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	_, err := client.API().CreateTopic(ctx, &api.CreateTopicRequest{Name: "events"})
+	require.NoError(t, err)
+
+	producer := client.NewProducer(ProducerConfig{MetadataMaxAge: 10 * time.Millisecond})
+	defer producer.Close()
+
+	send := func(key string) uint32 {
+		f, err := producer.Send(ctx, &Message{Topic: "events", Record: &api.Record{Value: []byte("hello"), Key: []byte(key)}}, nil)
+		require.NoError(t, err)
+		_, partition, err := f.Wait(ctx)
+		require.NoError(t, err)
+		return partition
+	}
+	require.Equal(t, uint32(0), send("user-1"))
+
+	// the topic's recreated with more partitions.
+	_, err = client.API().DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "events"})
+	require.NoError(t, err)
+	_, err = client.API().CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{Partitions: 8},
+	})
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	// keys hash over the new partition count, like the server does.
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		keyed, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("keyed"), Key: []byte(key)},
+			Topic:  "events",
+		})
+		require.NoError(t, err)
+		require.Equal(t, keyed.Partition, send(key))
+	}
+}
+
+func testProduceNotRetried(t *testing.T, client *Client, srv *testServer) {
+	srv.stop()
+	defer srv.start()
+
+	_, err := client.Produce(context.Background(), &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello")},
+	})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func testProducerClose(t *testing.T, client *Client, srv *testServer) {
+	ctx := context.Background()
+
+	producer := client.NewProducer(ProducerConfig{Linger: time.Hour})
+	f, err := producer.Send(ctx, &Message{Record: &api.Record{Value: []byte("hello")}}, nil)
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("record wasn't produced before close returned")
+	}
+	_, _, err = f.Wait(ctx)
+	require.NoError(t, err)
+
+	_, err = producer.Send(ctx, &Message{Record: &api.Record{Value: []byte("hello")}}, nil)
+	require.ErrorIs(t, err, ErrProducerClosed)
+}
+
+// flakyServer fails its first requests as if the server were unavailable.
+type flakyServer struct {
+	api.UnimplementedLogServer
+	mu    sync.Mutex
+	fails int
+	calls int
+}
+
+func (s *flakyServer) InitProducerId(ctx context.Context, req *api.InitProducerIdRequest) (*api.InitProducerIdResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls <= s.fails {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return &api.InitProducerIdResponse{ProducerId: 1}, nil
+}
+
+func TestRetries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	flaky := &flakyServer{fails: 2}
+	gsrv := grpc.NewServer()
+	api.RegisterLogServer(gsrv, flaky)
+	go gsrv.Serve(l)
+	defer gsrv.Stop()
+
+	config := Config{Addr: l.Addr().String()}
+	config.Retry.MinBackoff = time.Millisecond
+	client, err := New(config)
+	require.NoError(t, err)
+	defer client.Close()
+
+	id, err := client.InitProducerID(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), id)
+	require.Equal(t, 3, flaky.calls)
+
+	// requests fail once their retries run out.
+	flaky.calls, flaky.fails = 0, 10
+	client.Config.Retry.MaxRetries = 2
+	_, err = client.InitProducerID(context.Background())
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, 3, flaky.calls)
+
+	// and aren't retried when they can't succeed.
+	require.False(t, Retryable(status.Error(codes.InvalidArgument, "invalid")))
+}
+
+func produce(t *testing.T, client *Client, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, err := client.Produce(context.Background(), &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+}
+
+// testServer is a Log server that can be stopped and started again on
+// the same address with the same data, like a server restarting.
+type testServer struct {
+	t    *testing.T
+	dir  string
+	addr string
+
+	gsrv   *grpc.Server
+	clog   *log.Log
+	topics *topic.Registry
+	txns   *txn.Coordinator
+	done   chan struct{}
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "client-test")
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(dir+"/default", 0755))
+	require.NoError(t, os.Mkdir(dir+"/transactions", 0755))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &testServer{t: t, dir: dir, addr: l.Addr().String()}
+	srv.serve(l)
+	return srv
+}
+
+func (s *testServer) start() {
+	s.t.Helper()
+
+	l, err := net.Listen("tcp", s.addr)
+	require.NoError(s.t, err)
+	s.serve(l)
+}
+
+func (s *testServer) serve(l net.Listener) {
+	s.t.Helper()
+
+	var err error
+	s.clog, err = log.NewLog(s.dir+"/default", log.Config{})
+	require.NoError(s.t, err)
+
+	s.topics, err = topic.NewRegistry(s.dir+"/topics", topic.Config{})
+	require.NoError(s.t, err)
+
+	s.txns, err = txn.NewCoordinator(s.dir+"/transactions", txn.Config{
+		Partition: server.TransactionPartitions(s.clog, s.topics),
+	})
+	require.NoError(s.t, err)
+
+	s.gsrv, err = server.NewGRPCServer(&server.Config{
+		CommitLog:    s.clog,
+		Topics:       s.topics,
+		Transactions: s.txns,
+	})
+	require.NoError(s.t, err)
+
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		s.gsrv.Serve(l)
+	}()
+}
+
+func (s *testServer) stop() {
+	s.t.Helper()
+
+	s.gsrv.Stop()
+	<-s.done
+	require.NoError(s.t, s.clog.Close())
+	require.NoError(s.t, s.topics.Close())
+	require.NoError(s.t, s.txns.Close())
+}
+
+func (s *testServer) remove() {
+	s.stop()
+	os.RemoveAll(s.dir)
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+)
+
+type ConsumerConfig struct {
+	// Topic and partition to consume, the server's
+	// default log when the topic's empty.
+	Topic     string
+	Partition uint32
+	// Offset of the first record to consume.
+	Offset    uint64
+	Isolation api.IsolationLevel
+	// Limits of each batch fetched, the server's defaults when zero.
+	MaxRecords uint32
+	MaxBytes   uint64
+	// How long to wait before fetching again once the consumer's
+	// caught up with the partition. Defaults to 100ms.
+	PollInterval time.Duration
+}
+
+// Consumer reads a partition's records in order, fetching them in batches.
+// It keeps track of the next offset to read, so it carries on from where
+// it was after failed requests and reconnects. It's not safe for
+// concurrent use.
+type Consumer struct {
+	client *Client
+	config ConsumerConfig
+
+	next    uint64
+	records []*api.Record
+}
+
+// NewConsumer returns a consumer reading through the client.
+func (c *Client) NewConsumer(config ConsumerConfig) *Consumer {
+	if config.PollInterval == 0 {
+		config.PollInterval = 100 * time.Millisecond
+	}
+
+	return &Consumer{
+		client: c,
+		config: config,
+		next:   config.Offset,
+	}
+}
+
+// Next returns the next record, waiting for one to be produced
+// if the consumer's caught up, until ctx is done. When it fails
+// the consumer's position doesn't change, so it can be called
+// again to retry. Positions whose records were deleted fail with
+// codes.OutOfRange, Seek past them to carry on.
+func (c *Consumer) Next(ctx context.Context) (*api.Record, error) {
+	for len(c.records) == 0 {
+		res, err := c.client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{
+			Offset:     c.next,
+			Topic:      c.config.Topic,
+			Partition:  c.config.Partition,
+			Isolation:  c.config.Isolation,
+			MaxRecords: c.config.MaxRecords,
+			MaxBytes:   c.config.MaxBytes,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(res.Records) > 0 {
+			c.records = res.Records
+			break
+		}
+
+		// Read committed batches can skip over aborted
+		// and control records without returning any.
+		if res.NextOffset > c.next {
+			c.next = res.NextOffset
+			continue
+		}
+
+		select {
+		case <-time.After(c.config.PollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	record := c.records[0]
+	c.records = c.records[1:]
+	c.next = record.Offset + 1
+	return record, nil
+}
+
+// Offset returns the offset the consumer reads from next.
+func (c *Consumer) Offset() uint64 {
+	return c.next
+}
+
+// Seek moves the consumer to read from off next.
+func (c *Consumer) Seek(off uint64) {
+	c.next = off
+	c.records = nil
+}
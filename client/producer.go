@@ -0,0 +1,508 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Number of requests a producer has in flight per partition, which is
+// as many as the server remembers to deduplicate retries of.
+const producerWindow = 5
+
+var (
+	ErrProducerClosed = fmt.Errorf("producer is closed")
+	ErrTopicNotFound  = fmt.Errorf("topic not found")
+)
+
+type ProducerConfig struct {
+	// Number of records sent together once they're buffered.
+	// Defaults to 100.
+	BatchSize int
+	// How long a batch waits to fill up before it's sent anyway.
+	// Defaults to 5ms.
+	Linger time.Duration
+	// Number of records buffered before Send blocks.
+	// Defaults to 1000.
+	BufferSize int
+	// How long topics' partition counts are cached before they're
+	// listed again, so records are partitioned over partitions added
+	// since. Defaults to 5 minutes.
+	MetadataMaxAge time.Duration
+}
+
+// Message is a record to produce to a topic's partition.
+type Message struct {
+	// Topic to produce to, the server's default log when empty.
+	Topic string
+	// Partition to produce to. When nil, records with a key go to
+	// the partition picked by hashing it like the server does, and
+	// records without one are spread round-robin.
+	Partition *uint32
+	Record    *api.Record
+}
+
+// Future is the result of producing a message, which
+// is available once the message is produced or fails.
+type Future struct {
+	done      chan struct{}
+	offset    uint64
+	partition uint32
+	err       error
+}
+
+// Done returns a channel that's closed once the result is available.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the message to be produced and returns the offset
+// and partition it was appended to, or why it wasn't.
+func (f *Future) Wait(ctx context.Context) (offset uint64, partition uint32, err error) {
+	select {
+	case <-f.done:
+		return f.offset, f.partition, f.err
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	}
+}
+
+// pending is a message waiting to be produced, or a flush marker
+// when flushed is set.
+type pending struct {
+	msg       *Message
+	partition uint32
+	callback  func(offset uint64, partition uint32, err error)
+	future    *Future
+	flushed   chan struct{}
+}
+
+func (p *pending) complete(offset uint64, partition uint32, err error) {
+	p.future.offset, p.future.partition, p.future.err = offset, partition, err
+	if p.callback != nil {
+		p.callback(offset, partition, err)
+	}
+	close(p.future.done)
+}
+
+// session is an idempotent producer ID along with the
+// next sequence number for each partition it produces to.
+type session struct {
+	id   uint64
+	seqs map[partitionKey]uint64
+}
+
+type partitionKey struct {
+	topic     string
+	partition uint32
+}
+
+// Producer buffers messages and produces them in batches in the
+// background. It's idempotent, so failed requests are retried without
+// duplicating records, and records are appended to each partition in
+// the order they're sent. It's safe for concurrent use.
+type Producer struct {
+	client *Client
+	config ProducerConfig
+
+	// Guards closing the queue against sends.
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *pending
+	done   chan struct{}
+
+	// Guards the session between the batch's partitions.
+	sessionMu sync.Mutex
+	session   *session
+
+	// Partition counts of the topics produced to, when they were
+	// listed and the next partition for records without a key.
+	// Only used by run.
+	partitions map[string]uint32
+	listed     time.Time
+	next       map[string]uint32
+}
+
+// NewProducer returns a producer sending messages through the client.
+func (c *Client) NewProducer(config ProducerConfig) *Producer {
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	if config.Linger == 0 {
+		config.Linger = 5 * time.Millisecond
+	}
+	if config.BufferSize == 0 {
+		config.BufferSize = 1000
+	}
+	if config.MetadataMaxAge == 0 {
+		config.MetadataMaxAge = 5 * time.Minute
+	}
+
+	p := &Producer{
+		client:     c,
+		config:     config,
+		queue:      make(chan *pending, config.BufferSize),
+		done:       make(chan struct{}),
+		partitions: make(map[string]uint32),
+		next:       make(map[string]uint32),
+	}
+	go p.run()
+	return p
+}
+
+// Send buffers msg to be produced, blocking while the buffer's full until
+// ctx is done. The returned future and callback, if it's not nil, get the
+// result once it's produced. Callbacks are called from the producer's
+// goroutines and should return quickly.
+func (p *Producer) Send(ctx context.Context, msg *Message, callback func(offset uint64, partition uint32, err error)) (*Future, error) {
+	pend := &pending{
+		msg:      msg,
+		callback: callback,
+		future:   &Future{done: make(chan struct{})},
+	}
+	if err := p.enqueue(ctx, pend); err != nil {
+		return nil, err
+	}
+	return pend.future, nil
+}
+
+// Flush waits for every message sent before it's called to be produced.
+func (p *Producer) Flush(ctx context.Context) error {
+	pend := &pending{flushed: make(chan struct{})}
+	if err := p.enqueue(ctx, pend); err != nil {
+		return err
+	}
+
+	select {
+	case <-pend.flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close produces the buffered messages and stops the producer.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+	return nil
+}
+
+func (p *Producer) enqueue(ctx context.Context, pend *pending) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrProducerClosed
+	}
+
+	select {
+	case p.queue <- pend:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects buffered messages into batches and produces them
+// until the producer's closed.
+func (p *Producer) run() {
+	defer close(p.done)
+
+	for {
+		pend, ok := <-p.queue
+		if !ok {
+			return
+		}
+
+		batch := []*pending{pend}
+		linger := time.NewTimer(p.config.Linger)
+	collect:
+		for len(batch) < p.config.BatchSize && pend.flushed == nil {
+			select {
+			case pend, ok = <-p.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, pend)
+			case <-linger.C:
+				break collect
+			}
+		}
+		linger.Stop()
+
+		p.produce(batch)
+	}
+}
+
+// produce sends the batch's messages, each partition's in order and
+// the partitions in parallel, and waits for them all to finish.
+func (p *Producer) produce(batch []*pending) {
+	var (
+		flushes []*pending
+		order   []partitionKey
+		groups  = make(map[partitionKey][]*pending)
+	)
+	for _, pend := range batch {
+		if pend.flushed != nil {
+			flushes = append(flushes, pend)
+			continue
+		}
+
+		partition, err := p.partitionFor(pend.msg)
+		if err != nil {
+			pend.complete(0, 0, err)
+			continue
+		}
+		pend.partition = partition
+
+		key := partitionKey{pend.msg.Topic, partition}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], pend)
+	}
+
+	if len(order) > 0 {
+		s, err := p.currentSession()
+		if err != nil {
+			for _, key := range order {
+				for _, pend := range groups[key] {
+					pend.complete(0, key.partition, err)
+				}
+			}
+			order = nil
+		}
+
+		var wg sync.WaitGroup
+		for _, key := range order {
+			wg.Add(1)
+			go func(key partitionKey) {
+				defer wg.Done()
+				p.producePartition(s, key, groups[key])
+			}(key)
+		}
+		wg.Wait()
+	}
+
+	for _, pend := range flushes {
+		close(pend.flushed)
+	}
+}
+
+// producePartition sends a partition's messages in order, pipelining up to
+// producerWindow requests at a time. A sequence is used up only by records
+// that were appended, so records that failed outright are skipped. Requests
+// the server rejected because they were sent with the wrong sequence, or
+// reached it before the requests ahead of them, are sent again once nothing
+// else is in flight. When it's unknown whether a record was appended the
+// session's abandoned, along with the partition's remaining records, so
+// they can't be appended out of order and later messages are produced
+// under a new producer ID.
+func (p *Producer) producePartition(s *session, key partitionKey, pends []*pending) {
+	p.sessionMu.Lock()
+	seq, acked := s.seqs[key]
+	p.sessionMu.Unlock()
+
+	var inflight []*request
+	for len(pends) > 0 || len(inflight) > 0 {
+		// Producers new to a partition may start at any sequence, so
+		// the first record's sent alone in case a later one overtakes it.
+		window := 1
+		if acked {
+			window = producerWindow
+		}
+		for len(pends) > 0 && len(inflight) < window {
+			inflight = append(inflight, p.send(s, key, pends[0], seq+uint64(len(inflight)), len(inflight) > 0))
+			pends = pends[1:]
+		}
+
+		r := inflight[0]
+		res, err := r.wait()
+		var resend []*pending
+		switch {
+		case err == nil:
+			seq, acked = r.seq+1, true
+			p.sessionMu.Lock()
+			s.seqs[key] = seq
+			p.sessionMu.Unlock()
+			r.pend.complete(res.Offset, res.Partition, nil)
+			inflight = inflight[1:]
+			continue
+		case failed(err):
+			// The requests after it were sent with sequences one too high.
+			r.pend.complete(0, key.partition, err)
+		case r.pipelined && status.Code(err) == codes.FailedPrecondition:
+			resend = append(resend, r.pend)
+		default:
+			p.abandon(s, key, err, inflight, pends)
+			return
+		}
+
+		// None of the requests still in flight can be appended now, but
+		// they're only sent again once the server's rejected them.
+		for i, r := range inflight[1:] {
+			if _, rerr := r.wait(); !rejected(rerr) {
+				for _, pend := range resend {
+					pend.complete(0, key.partition, err)
+				}
+				p.abandon(s, key, err, inflight[1+i:], pends)
+				return
+			}
+			resend = append(resend, r.pend)
+		}
+		inflight = nil
+		pends = append(resend, pends...)
+	}
+}
+
+// request is a message being produced with a sequence number.
+type request struct {
+	pend *pending
+	seq  uint64
+	// Set when it was sent while earlier requests were in flight,
+	// so it may have reached the server before them.
+	pipelined bool
+
+	done chan struct{}
+	res  *api.ProduceResponse
+	err  error
+}
+
+func (r *request) wait() (*api.ProduceResponse, error) {
+	<-r.done
+	return r.res, r.err
+}
+
+// send produces the message with seq in the background.
+func (p *Producer) send(s *session, key partitionKey, pend *pending, seq uint64, pipelined bool) *request {
+	r := &request{pend: pend, seq: seq, pipelined: pipelined, done: make(chan struct{})}
+	partition := key.partition
+	go func() {
+		defer close(r.done)
+		r.res, r.err = p.client.Produce(context.Background(), &api.ProduceRequest{
+			Record:     pend.msg.Record,
+			Topic:      key.topic,
+			Partition:  &partition,
+			ProducerId: s.id,
+			Sequence:   seq,
+		})
+	}()
+	return r
+}
+
+// abandon gives up on the session once it's unknown whether a record was
+// appended. The requests in flight complete with their own results and
+// the partition's remaining messages fail with err.
+func (p *Producer) abandon(s *session, key partitionKey, err error, inflight []*request, pends []*pending) {
+	p.sessionMu.Lock()
+	if p.session == s {
+		p.session = nil
+	}
+	p.sessionMu.Unlock()
+
+	for _, r := range inflight {
+		res, err := r.wait()
+		if err != nil {
+			r.pend.complete(0, key.partition, err)
+			continue
+		}
+		r.pend.complete(res.Offset, res.Partition, nil)
+	}
+	for _, pend := range pends {
+		pend.complete(0, key.partition, err)
+	}
+}
+
+// currentSession returns the producer's session,
+// getting a new producer ID if it doesn't have one.
+func (p *Producer) currentSession() (*session, error) {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+
+	if p.session != nil {
+		return p.session, nil
+	}
+
+	id, err := p.client.InitProducerID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	p.session = &session{id: id, seqs: make(map[partitionKey]uint64)}
+	return p.session, nil
+}
+
+// partitionFor returns the partition msg is produced to.
+func (p *Producer) partitionFor(msg *Message) (uint32, error) {
+	if msg.Partition != nil {
+		return *msg.Partition, nil
+	}
+	if msg.Topic == "" {
+		return 0, nil
+	}
+
+	n, ok := p.partitions[msg.Topic]
+	if !ok || time.Since(p.listed) >= p.config.MetadataMaxAge {
+		// A stale count is better than failing the record.
+		if err := p.listPartitions(); err != nil && !ok {
+			return 0, err
+		}
+		if n, ok = p.partitions[msg.Topic]; !ok {
+			return 0, fmt.Errorf("%w: %s", ErrTopicNotFound, msg.Topic)
+		}
+	}
+
+	if key := msg.Record.GetKey(); len(key) > 0 {
+		h := fnv.New32a()
+		h.Write(key)
+		return h.Sum32() % n, nil
+	}
+
+	partition := p.next[msg.Topic] % n
+	p.next[msg.Topic]++
+	return partition, nil
+}
+
+// listPartitions caches every topic's partition count.
+func (p *Producer) listPartitions() error {
+	topics, err := p.client.ListTopics(context.Background())
+	if err != nil {
+		return err
+	}
+
+	p.partitions = make(map[string]uint32, len(topics))
+	for _, t := range topics {
+		p.partitions[t.Name] = max(t.GetConfig().GetPartitions(), 1)
+	}
+	p.listed = time.Now()
+	return nil
+}
+
+// failed returns whether a request that failed with err
+// definitely wasn't applied, because the server rejected it.
+func failed(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied, codes.Unauthenticated, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+// rejected returns whether a request that failed with err wasn't
+// applied, including because it was sent with the wrong sequence.
+func rejected(err error) bool {
+	return failed(err) || status.Code(err) == codes.FailedPrecondition
+}
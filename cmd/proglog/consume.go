@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/client"
+)
+
+// consumeFlags are the flags shared by consume and tail.
+type consumeFlags struct {
+	conn         connFlags
+	topic        string
+	partition    uint
+	from         string
+	max          uint64
+	format       string
+	delim        string
+	printOffsets bool
+	committed    bool
+}
+
+func (c *consumeFlags) register(fs *flag.FlagSet, from string) {
+	c.conn.register(fs)
+	fs.StringVar(&c.topic, "topic", "", "topic to consume, the server's default log when empty")
+	fs.UintVar(&c.partition, "partition", 0, "partition to consume")
+	fs.StringVar(&c.from, "from", from, `offset to consume from, "earliest" or "latest"`)
+	fs.Uint64Var(&c.max, "n", 0, "stop after this many records, no limit when 0")
+	fs.StringVar(&c.format, "format", "raw", "how records are printed: raw values, json or hex encoded values")
+	fs.StringVar(&c.delim, "delim", "line", "how raw values are delimited: line, or length for uvarint length-prefixed values")
+	fs.BoolVar(&c.printOffsets, "print-offsets", false, "print each record's offset and a tab before line delimited values")
+	fs.BoolVar(&c.committed, "read-committed", false, "skip records of open and aborted transactions")
+}
+
+func (c *consumeFlags) isolation() api.IsolationLevel {
+	if c.committed {
+		return api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED
+	}
+	return api.IsolationLevel_ISOLATION_LEVEL_READ_UNCOMMITTED
+}
+
+// offset resolves the -from flag against the partition's offsets.
+func (c *consumeFlags) offset(offsets *api.ListOffsetsResponse) (uint64, error) {
+	switch c.from {
+	case "earliest":
+		return offsets.LowOffset, nil
+	case "latest":
+		return offsets.HighOffset, nil
+	}
+
+	off, err := strconv.ParseUint(c.from, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid offset %q, must be a number, "earliest" or "latest"`, c.from)
+	}
+	return off, nil
+}
+
+// printer writes records to stdout in the format set by the flags.
+func (c *consumeFlags) printer(stdout io.Writer) (func(*api.Record) error, *bufio.Writer, error) {
+	w := bufio.NewWriter(stdout)
+
+	if c.delim != "line" && c.delim != "length" {
+		return nil, nil, fmt.Errorf("unknown delimiter %q", c.delim)
+	}
+	if c.delim == "length" && (c.format != "raw" || c.printOffsets) {
+		return nil, nil, fmt.Errorf("length delimited output only holds raw values")
+	}
+
+	prefix := func(r *api.Record) {
+		if c.printOffsets {
+			w.WriteString(strconv.FormatUint(r.Offset, 10))
+			w.WriteByte('\t')
+		}
+	}
+
+	switch c.format {
+	case "raw":
+		if c.delim == "length" {
+			return func(r *api.Record) error {
+				w.Write(binary.AppendUvarint(nil, uint64(len(r.Value))))
+				_, err := w.Write(r.Value)
+				return err
+			}, w, nil
+		}
+		return func(r *api.Record) error {
+			prefix(r)
+			w.Write(r.Value)
+			return w.WriteByte('\n')
+		}, w, nil
+	case "hex":
+		return func(r *api.Record) error {
+			prefix(r)
+			w.WriteString(hex.EncodeToString(r.Value))
+			return w.WriteByte('\n')
+		}, w, nil
+	case "json":
+		// Keys and values are base64 encoded, like any []byte.
+		enc := json.NewEncoder(w)
+		return func(r *api.Record) error {
+			return enc.Encode(struct {
+				Offset          uint64            `json:"offset"`
+				Key             []byte            `json:"key,omitempty"`
+				Value           []byte            `json:"value"`
+				Timestamp       int64             `json:"timestamp,omitempty"`
+				AppendTimestamp int64             `json:"append_timestamp,omitempty"`
+				Headers         map[string]string `json:"headers,omitempty"`
+			}{r.Offset, r.Key, r.Value, r.Timestamp, r.AppendTimestamp, r.Headers})
+		}, w, nil
+	}
+	return nil, nil, fmt.Errorf("unknown format %q", c.format)
+}
+
+func consume(ctx context.Context, fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	var flags consumeFlags
+	flags.register(fs, "earliest")
+	to := fs.String("to", "latest", `offset to stop before, or "latest" for the end of the partition when the command starts`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\nPrints the records from -from up to -to.\n\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	printRecord, w, err := flags.printer(stdout)
+	if err != nil {
+		return err
+	}
+	defer w.Flush()
+
+	c, err := flags.conn.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	offsets, err := c.ListOffsets(ctx, flags.topic, uint32(flags.partition))
+	if err != nil {
+		return err
+	}
+	off, err := flags.offset(offsets)
+	if err != nil {
+		return err
+	}
+	end := offsets.HighOffset
+	if *to != "latest" {
+		if end, err = strconv.ParseUint(*to, 10, 64); err != nil {
+			return fmt.Errorf(`invalid offset %q, must be a number or "latest"`, *to)
+		}
+	}
+
+	var n uint64
+	for off < end && (flags.max == 0 || n < flags.max) {
+		res, err := c.ConsumeBatch(ctx, &api.ConsumeBatchRequest{
+			Offset:    off,
+			Topic:     flags.topic,
+			Partition: uint32(flags.partition),
+			Isolation: flags.isolation(),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, r := range res.Records {
+			if r.Offset >= end || (flags.max > 0 && n >= flags.max) {
+				break
+			}
+			if err = printRecord(r); err != nil {
+				return err
+			}
+			n++
+		}
+
+		// The partition ends before -to.
+		if res.NextOffset <= off {
+			break
+		}
+		off = res.NextOffset
+	}
+
+	return w.Flush()
+}
+
+func tail(ctx context.Context, fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	var flags consumeFlags
+	flags.register(fs, "latest")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\nPrints the records from -from onwards as they're produced, until interrupted.\n\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	printRecord, w, err := flags.printer(stdout)
+	if err != nil {
+		return err
+	}
+	defer w.Flush()
+
+	c, err := flags.conn.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	offsets, err := c.ListOffsets(ctx, flags.topic, uint32(flags.partition))
+	if err != nil {
+		return err
+	}
+	off, err := flags.offset(offsets)
+	if err != nil {
+		return err
+	}
+
+	consumer := c.NewConsumer(client.ConsumerConfig{
+		Topic:     flags.topic,
+		Partition: uint32(flags.partition),
+		Offset:    off,
+		Isolation: flags.isolation(),
+	})
+	for n := uint64(0); flags.max == 0 || n < flags.max; n++ {
+		r, err := consumer.Next(ctx)
+		// Being interrupted is how tailing normally ends.
+		if err != nil && ctx.Err() != nil {
+			return w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = printRecord(r); err != nil {
+			return err
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
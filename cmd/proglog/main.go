@@ -0,0 +1,152 @@
+// Command proglog produces records to and consumes records from a Log
+// server over gRPC.
+//
+// Usage:
+//
+//	proglog produce [flags] [records...]
+//	proglog consume [flags]
+//	proglog tail [flags]
+//	proglog offsets [flags]
+//
+// Run proglog <command> -h for each command's flags.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/masonictemple4/proglog/client"
+)
+
+const usage = `Usage: proglog <command> [flags]
+
+Commands:
+  produce   produce records from arguments or stdin
+  consume   print a range of records
+  tail      print records as they're produced
+  offsets   print a partition's offsets
+
+Run proglog <command> -h for each command's flags.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "proglog: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by args[0] with the rest of args.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	}
+
+	var cmd func(ctx context.Context, fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error
+	switch args[0] {
+	case "produce":
+		cmd = produce
+	case "consume":
+		cmd = consume
+	case "tail":
+		cmd = tail
+	case "offsets":
+		cmd = offsets
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	default:
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	fs := flag.NewFlagSet("proglog "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return cmd(ctx, fs, args[1:], stdin, stdout)
+}
+
+// connFlags are the flags every command connects to the server with.
+type connFlags struct {
+	addr       string
+	timeout    time.Duration
+	tls        bool
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	skipVerify bool
+}
+
+func (c *connFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.addr, "addr", "127.0.0.1:8400", "address of the server")
+	fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "deadline of each request")
+	fs.BoolVar(&c.tls, "tls", false, "connect with TLS, implied by the other -tls flags")
+	fs.StringVar(&c.caFile, "tls-ca", "", "PEM file of the CA to verify the server with, the system's CAs by default")
+	fs.StringVar(&c.certFile, "tls-cert", "", "PEM file of the client's certificate")
+	fs.StringVar(&c.keyFile, "tls-key", "", "PEM file of the client certificate's key")
+	fs.StringVar(&c.serverName, "tls-server-name", "", "name to verify the server's certificate with, the address's host by default")
+	fs.BoolVar(&c.skipVerify, "tls-skip-verify", false, "don't verify the server's certificate")
+}
+
+// dial returns a client for the server the flags point to.
+func (c *connFlags) dial() (*client.Client, error) {
+	config := client.Config{Addr: c.addr, Timeout: c.timeout}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.TLS = tlsConfig
+
+	return client.New(config)
+}
+
+// tlsConfig returns the TLS config set by the flags,
+// or nil if the connection is insecure.
+func (c *connFlags) tlsConfig() (*tls.Config, error) {
+	if !c.tls && c.caFile == "" && c.certFile == "" && c.keyFile == "" && c.serverName == "" && !c.skipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         c.serverName,
+		InsecureSkipVerify: c.skipVerify,
+	}
+
+	if c.caFile != "" {
+		b, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", c.caFile)
+		}
+	}
+
+	if c.certFile != "" || c.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/log"
+	"github.com/masonictemple4/proglog/internal/server"
+	"github.com/masonictemple4/proglog/internal/topic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, addr string){
+		"produce arguments and consume them":           testProduceConsume,
+		"produce and consume length delimited records": testLengthDelimited,
+		"consume in json and hex":                      testFormats,
+		"tail waits for records to be produced":        testTail,
+		"print a partition's offsets":                  testOffsets,
+		"produce to a topic's partitions":              testProduceTopic,
+		"unknown commands and bad flags are rejected":  testBadUsage,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			addr, teardown := setupTest(t)
+			defer teardown()
+			fn(t, addr)
+		})
+	}
+}
+
+func setupTest(t *testing.T) (string, func()) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "proglog-cmd-test")
+	require.NoError(t, err)
+
+	require.NoError(t, os.Mkdir(dir+"/default", 0755))
+	clog, err := log.NewLog(dir+"/default", log.Config{})
+	require.NoError(t, err)
+
+	topics, err := topic.NewRegistry(dir+"/topics", topic.Config{})
+	require.NoError(t, err)
+
+	gsrv, err := server.NewGRPCServer(&server.Config{CommitLog: clog, Topics: topics})
+	require.NoError(t, err)
+
+	go func() {
+		gsrv.Serve(l)
+	}()
+
+	return l.Addr().String(), func() {
+		gsrv.Stop()
+		clog.Remove()
+		topics.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// runCmd runs the command against the server at addr,
+// returning what it printed.
+func runCmd(t *testing.T, addr string, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	args = append([]string{args[0], "-addr", addr}, args[1:]...)
+	err := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func testProduceConsume(t *testing.T, addr string) {
+	out, err := runCmd(t, addr, "", "produce", "hello", "world")
+	require.NoError(t, err)
+	require.Equal(t, "0\t0\n0\t1\n", out)
+
+	out, err = runCmd(t, addr, "first\nsecond\nthird", "produce", "-format", "json")
+	require.NoError(t, err)
+	require.Equal(t, `{"partition":0,"offset":2}`+"\n"+`{"partition":0,"offset":3}`+"\n"+`{"partition":0,"offset":4}`+"\n", out)
+
+	out, err = runCmd(t, addr, "", "consume")
+	require.NoError(t, err)
+	require.Equal(t, "hello\nworld\nfirst\nsecond\nthird\n", out)
+
+	out, err = runCmd(t, addr, "", "consume", "-from", "1", "-to", "4", "-print-offsets")
+	require.NoError(t, err)
+	require.Equal(t, "1\tworld\n2\tfirst\n3\tsecond\n", out)
+
+	out, err = runCmd(t, addr, "", "consume", "-from", "3", "-n", "1")
+	require.NoError(t, err)
+	require.Equal(t, "second\n", out)
+}
+
+func testLengthDelimited(t *testing.T, addr string) {
+	var in []byte
+	for _, v := range []string{"multi\nline", "", "bytes\x00"} {
+		in = binary.AppendUvarint(in, uint64(len(v)))
+		in = append(in, v...)
+	}
+
+	_, err := runCmd(t, addr, string(in), "produce", "-delim", "length")
+	require.NoError(t, err)
+
+	out, err := runCmd(t, addr, "", "consume", "-delim", "length")
+	require.NoError(t, err)
+	require.Equal(t, string(in), out)
+
+	// truncated records aren't produced.
+	_, err = runCmd(t, addr, "\x05abc", "produce", "-delim", "length")
+	require.Error(t, err)
+}
+
+func testFormats(t *testing.T, addr string) {
+	_, err := runCmd(t, addr, "", "produce", "-key", "user-1", "hi")
+	require.NoError(t, err)
+
+	out, err := runCmd(t, addr, "", "consume", "-format", "hex")
+	require.NoError(t, err)
+	require.Equal(t, "6869\n", out)
+
+	out, err = runCmd(t, addr, "", "consume", "-format", "json")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(out, `{"offset":0,"key":"dXNlci0x","value":"aGk=","append_timestamp":`), out)
+
+	_, err = runCmd(t, addr, "", "consume", "-format", "json", "-delim", "length")
+	require.Error(t, err)
+}
+
+func testTail(t *testing.T, addr string) {
+	_, err := runCmd(t, addr, "", "produce", "first", "second")
+	require.NoError(t, err)
+
+	produced := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, err := runCmd(t, addr, "", "produce", "third")
+		produced <- err
+	}()
+
+	out, err := runCmd(t, addr, "", "tail", "-from", "1", "-n", "2")
+	require.NoError(t, err)
+	require.Equal(t, "second\nthird\n", out)
+	require.NoError(t, <-produced)
+
+	// tailing from latest ends cleanly when it's interrupted.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	var stdout, stderr bytes.Buffer
+	err = run(ctx, []string{"tail", "-addr", addr}, strings.NewReader(""), &stdout, &stderr)
+	require.NoError(t, err)
+	require.Empty(t, stdout.String())
+}
+
+func testOffsets(t *testing.T, addr string) {
+	_, err := runCmd(t, addr, "", "produce", "a", "b", "c")
+	require.NoError(t, err)
+
+	out, err := runCmd(t, addr, "", "offsets")
+	require.NoError(t, err)
+	require.Equal(t, "low\t0\nhigh\t3\nlast stable\t3\n", out)
+
+	out, err = runCmd(t, addr, "", "offsets", "-format", "json")
+	require.NoError(t, err)
+	require.Equal(t, `{"low_offset":0,"high_offset":3,"last_stable_offset":3}`+"\n", out)
+
+	_, err = runCmd(t, addr, "", "offsets", "-topic", "missing")
+	require.Error(t, err)
+}
+
+func testProduceTopic(t *testing.T, addr string) {
+	c, err := (&connFlags{addr: addr}).dial()
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.API().CreateTopic(context.Background(), &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{Partitions: 2},
+	})
+	require.NoError(t, err)
+
+	out, err := runCmd(t, addr, "", "produce", "-topic", "events", "-partition", "1", "hello")
+	require.NoError(t, err)
+	require.Equal(t, "1\t0\n", out)
+
+	out, err = runCmd(t, addr, "", "consume", "-topic", "events", "-partition", "1")
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", out)
+}
+
+func testBadUsage(t *testing.T, addr string) {
+	_, err := runCmd(t, addr, "", "produce", "-delim", "comma", "a")
+	require.NoError(t, err, "the delimiter only applies to stdin")
+
+	_, err = runCmd(t, addr, "", "produce", "-delim", "comma")
+	require.Error(t, err)
+
+	_, err = runCmd(t, addr, "", "consume", "-from", "oldest")
+	require.Error(t, err)
+
+	_, err = runCmd(t, addr, "", "frobnicate")
+	require.Error(t, err)
+}
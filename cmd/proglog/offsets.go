@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+func offsets(ctx context.Context, fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	var conn connFlags
+	conn.register(fs)
+	topic := fs.String("topic", "", "topic of the partition, the server's default log when empty")
+	partition := fs.Uint("partition", 0, "partition to print the offsets of")
+	format := fs.String("format", "text", "how the offsets are printed: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\nPrints the partition's first offset that can be consumed, the offset its\nnext record will be appended at and its last stable offset.\n\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	c, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	res, err := c.ListOffsets(ctx, *topic, uint32(*partition))
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		return json.NewEncoder(stdout).Encode(struct {
+			Low        uint64 `json:"low_offset"`
+			High       uint64 `json:"high_offset"`
+			LastStable uint64 `json:"last_stable_offset"`
+		}{res.LowOffset, res.HighOffset, res.LastStableOffset})
+	}
+
+	_, err = fmt.Fprintf(stdout, "low\t%d\nhigh\t%d\nlast stable\t%d\n", res.LowOffset, res.HighOffset, res.LastStableOffset)
+	return err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/client"
+)
+
+// Largest length-delimited record read from stdin, so a corrupt
+// length can't make us allocate an arbitrary amount of memory.
+const maxRecordBytes = 64 << 20
+
+func produce(ctx context.Context, fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	var conn connFlags
+	conn.register(fs)
+	topic := fs.String("topic", "", "topic to produce to, the server's default log when empty")
+	partition := fs.Int("partition", -1, "partition to produce to, picked by the record's key when negative")
+	key := fs.String("key", "", "key of every record")
+	delim := fs.String("delim", "line", "how records are delimited on stdin: line, or length for uvarint length-prefixed records")
+	format := fs.String("format", "text", "how produced offsets are printed: text, json or none")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [records...]\n\nProduces each argument as a record, or records read from stdin when there are none.\n\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var next func() ([]byte, error)
+	switch {
+	case fs.NArg() > 0:
+		values := fs.Args()
+		next = func() ([]byte, error) {
+			if len(values) == 0 {
+				return nil, io.EOF
+			}
+			v := values[0]
+			values = values[1:]
+			return []byte(v), nil
+		}
+	case *delim == "line":
+		next = lineReader(bufio.NewReader(stdin))
+	case *delim == "length":
+		next = lengthReader(bufio.NewReader(stdin))
+	default:
+		return fmt.Errorf("unknown delimiter %q", *delim)
+	}
+
+	var printOffset func(offset uint64, partition uint32) error
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	switch *format {
+	case "text":
+		printOffset = func(offset uint64, partition uint32) error {
+			_, err := fmt.Fprintf(w, "%d\t%d\n", partition, offset)
+			return err
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		printOffset = func(offset uint64, partition uint32) error {
+			return enc.Encode(struct {
+				Partition uint32 `json:"partition"`
+				Offset    uint64 `json:"offset"`
+			}{partition, offset})
+		}
+	case "none":
+		printOffset = func(uint64, uint32) error { return nil }
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	c, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	producer := c.NewProducer(client.ProducerConfig{})
+	defer producer.Close()
+
+	// Records are read and sent while the results of those already
+	// sent are printed, in the order they were read.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	futures := make(chan *client.Future, 1000)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(futures)
+		readErr <- sendAll(ctx, producer, next, *topic, *partition, []byte(*key), futures)
+	}()
+
+	for f := range futures {
+		offset, partition, err := f.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		if err = printOffset(offset, partition); err != nil {
+			return err
+		}
+	}
+	if err = <-readErr; err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// sendAll sends every record next returns to the producer, passing
+// on each record's future, until next returns io.EOF.
+func sendAll(ctx context.Context, producer *client.Producer, next func() ([]byte, error), topic string, partition int, key []byte, futures chan<- *client.Future) error {
+	for {
+		value, err := next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		m := &client.Message{
+			Topic:  topic,
+			Record: &api.Record{Value: value, Key: key},
+		}
+		if partition >= 0 {
+			p := uint32(partition)
+			m.Partition = &p
+		}
+
+		f, err := producer.Send(ctx, m, nil)
+		if err != nil {
+			return err
+		}
+		select {
+		case futures <- f:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// lineReader returns a function reading records from r one
+// per line, without their trailing newlines.
+func lineReader(r *bufio.Reader) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(line, []byte("\n")), nil
+	}
+}
+
+// lengthReader returns a function reading records from r that are
+// each prefixed by their length, encoded as a uvarint.
+func lengthReader(r *bufio.Reader) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxRecordBytes {
+			return nil, fmt.Errorf("record length %d is over the %d byte limit", n, maxRecordBytes)
+		}
+
+		b := make([]byte, n)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("reading record: %w", err)
+		}
+		return b, nil
+	}
+}
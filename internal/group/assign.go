@@ -0,0 +1,134 @@
+package group
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Strategy decides how a group's partitions are divided
+// between its members.
+type Strategy int
+
+const (
+	// Range gives each member a contiguous range of every
+	// topic's partitions.
+	Range Strategy = iota
+	// RoundRobin deals every topic's partitions out to
+	// the members in turn.
+	RoundRobin
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case Range:
+		return "range"
+	case RoundRobin:
+		return "roundrobin"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// TopicPartition identifies a single partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition uint32
+}
+
+// assignFunc maps each member to the partitions it should consume.
+// subscriptions holds the topics each member is subscribed to and
+// partitions the number of partitions in every subscribed topic.
+type assignFunc func(subscriptions map[string][]string, partitions map[string]uint32) map[string][]TopicPartition
+
+func (s Strategy) valid() bool {
+	return s == Range || s == RoundRobin
+}
+
+func (s Strategy) assign() assignFunc {
+	if s == RoundRobin {
+		return assignRoundRobin
+	}
+	return assignRange
+}
+
+// assignRange splits each topic's partitions into contiguous ranges, one
+// per subscribed member in member ID order. When the partitions don't
+// divide evenly the first members get one extra.
+func assignRange(subscriptions map[string][]string, partitions map[string]uint32) map[string][]TopicPartition {
+	assignments := make(map[string][]TopicPartition, len(subscriptions))
+
+	for _, topic := range sortedTopics(partitions) {
+		members := subscribers(subscriptions, topic)
+		if len(members) == 0 {
+			continue
+		}
+
+		n, m := partitions[topic], uint32(len(members))
+		per, extra := n/m, n%m
+
+		var p uint32
+		for i, member := range members {
+			count := per
+			if uint32(i) < extra {
+				count++
+			}
+			for end := p + count; p < end; p++ {
+				assignments[member] = append(assignments[member], TopicPartition{Topic: topic, Partition: p})
+			}
+		}
+	}
+
+	return assignments
+}
+
+// assignRoundRobin deals every partition, ordered by topic then partition,
+// to the next member in member ID order that's subscribed to its topic.
+func assignRoundRobin(subscriptions map[string][]string, partitions map[string]uint32) map[string][]TopicPartition {
+	assignments := make(map[string][]TopicPartition, len(subscriptions))
+
+	members := make([]string, 0, len(subscriptions))
+	for member := range subscriptions {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	next := 0
+	for _, topic := range sortedTopics(partitions) {
+		for p := uint32(0); p < partitions[topic]; p++ {
+			// Find the next member subscribed to the topic, there's
+			// always at least one since the topic came from a subscription.
+			for i := 0; i < len(members); i++ {
+				member := members[(next+i)%len(members)]
+				if !slices.Contains(subscriptions[member], topic) {
+					continue
+				}
+				assignments[member] = append(assignments[member], TopicPartition{Topic: topic, Partition: p})
+				next = (next + i + 1) % len(members)
+				break
+			}
+		}
+	}
+
+	return assignments
+}
+
+// subscribers returns the members subscribed to topic sorted by ID.
+func subscribers(subscriptions map[string][]string, topic string) []string {
+	var members []string
+	for member, topics := range subscriptions {
+		if slices.Contains(topics, topic) {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+func sortedTopics(partitions map[string]uint32) []string {
+	topics := make([]string, 0, len(partitions))
+	for topic := range partitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
@@ -0,0 +1,71 @@
+package group
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignRange(t *testing.T) {
+	subscriptions := map[string][]string{
+		"a": {"events", "billing"},
+		"b": {"events", "billing"},
+	}
+	partitions := map[string]uint32{
+		"events":  3,
+		"billing": 2,
+	}
+
+	got := assignRange(subscriptions, partitions)
+	require.Equal(t, []TopicPartition{
+		{Topic: "billing", Partition: 0},
+		{Topic: "events", Partition: 0},
+		{Topic: "events", Partition: 1},
+	}, got["a"])
+	require.Equal(t, []TopicPartition{
+		{Topic: "billing", Partition: 1},
+		{Topic: "events", Partition: 2},
+	}, got["b"])
+}
+
+func TestAssignRoundRobin(t *testing.T) {
+	subscriptions := map[string][]string{
+		"a": {"events", "billing"},
+		"b": {"events"},
+		"c": {"events", "billing"},
+	}
+	partitions := map[string]uint32{
+		"events":  4,
+		"billing": 2,
+	}
+
+	got := assignRoundRobin(subscriptions, partitions)
+	require.Equal(t, []TopicPartition{
+		{Topic: "billing", Partition: 0},
+		{Topic: "events", Partition: 0},
+		{Topic: "events", Partition: 3},
+	}, got["a"])
+	require.Equal(t, []TopicPartition{
+		{Topic: "events", Partition: 1},
+	}, got["b"])
+	require.Equal(t, []TopicPartition{
+		{Topic: "billing", Partition: 1},
+		{Topic: "events", Partition: 2},
+	}, got["c"])
+}
+
+func TestAssignMoreMembersThanPartitions(t *testing.T) {
+	subscriptions := map[string][]string{
+		"a": {"events"},
+		"b": {"events"},
+		"c": {"events"},
+	}
+	partitions := map[string]uint32{"events": 2}
+
+	for _, s := range []Strategy{Range, RoundRobin} {
+		got := s.assign()(subscriptions, partitions)
+		require.Len(t, got["a"], 1, s.String())
+		require.Len(t, got["b"], 1, s.String())
+		require.Empty(t, got["c"], s.String())
+	}
+}
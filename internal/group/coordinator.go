@@ -0,0 +1,270 @@
+package group
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownMember        = fmt.Errorf("unknown group member")
+	ErrInconsistentStrategy = fmt.Errorf("assignment strategy doesn't match the group's")
+	ErrNoTopics             = fmt.Errorf("member must subscribe to at least one topic")
+	ErrUnknownStrategy      = fmt.Errorf("unknown assignment strategy")
+)
+
+// Config configures how long members may go without a heartbeat
+// and how the coordinator learns topics' partition counts.
+type Config struct {
+	// SessionTimeout is how long a member may go without a heartbeat
+	// before it's removed from its group. Defaults to 10 seconds.
+	SessionTimeout time.Duration
+	// Partitions returns the number of partitions in a topic,
+	// erroring when the topic doesn't exist.
+	Partitions func(topic string) (uint32, error)
+}
+
+// Assignment is a member's share of its group's partitions
+// for a single generation.
+type Assignment struct {
+	MemberID   string
+	Generation uint64
+	Partitions []TopicPartition
+}
+
+// Coordinator tracks the live members of every consumer group and
+// divides the partitions of the topics they subscribe to between them.
+//
+// Any membership change (a join, leave or expired session) starts a new
+// generation with fresh assignments. Members learn about it from their
+// next heartbeat, there's no barrier so a partition may briefly be
+// consumed by its old and new owner while they catch up. Groups are
+// removed once their last member leaves or expires.
+type Coordinator struct {
+	mu sync.Mutex
+
+	Config Config
+
+	groups map[string]*group
+	// Returns the time heartbeats are stamped with, tests
+	// replace it to expire sessions without waiting.
+	now func() time.Time
+}
+
+type group struct {
+	strategy   Strategy
+	generation uint64
+	members    map[string]*member
+}
+
+type member struct {
+	topics        []string
+	lastHeartbeat time.Time
+	assignment    []TopicPartition
+}
+
+// NewCoordinator returns a coordinator with no groups.
+func NewCoordinator(c Config) *Coordinator {
+	if c.SessionTimeout == 0 {
+		c.SessionTimeout = 10 * time.Second
+	}
+	return &Coordinator{
+		Config: c,
+		groups: make(map[string]*group),
+		now:    time.Now,
+	}
+}
+
+// Join adds a member subscribed to topics to the group and rebalances
+// it. An empty memberID joins as a new member, otherwise the existing
+// member rejoins (e.g. to change its subscription).
+func (c *Coordinator) Join(groupID, memberID string, topics []string, strategy Strategy) (*Assignment, error) {
+	if len(topics) == 0 {
+		return nil, ErrNoTopics
+	}
+	if !strategy.valid() {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, strategy)
+	}
+	for _, topic := range topics {
+		if _, err := c.Config.Partitions(topic); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	g, ok := c.groups[groupID]
+	if !ok {
+		g = &group{
+			strategy: strategy,
+			members:  make(map[string]*member),
+		}
+	}
+
+	if len(g.members) == 0 {
+		g.strategy = strategy
+	} else if g.strategy != strategy {
+		return nil, fmt.Errorf("%w: %s", ErrInconsistentStrategy, g.strategy)
+	}
+
+	if memberID == "" {
+		memberID = newMemberID()
+	} else if _, ok := g.members[memberID]; !ok {
+		return nil, ErrUnknownMember
+	}
+
+	g.members[memberID] = &member{
+		topics:        topics,
+		lastHeartbeat: c.now(),
+	}
+	c.rebalance(g)
+	c.groups[groupID] = g
+
+	return g.assignment(memberID), nil
+}
+
+// Heartbeat keeps the member's session alive and returns its current
+// assignment, which has changed when its generation doesn't match the
+// one the member last saw.
+func (c *Coordinator) Heartbeat(groupID, memberID string) (*Assignment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	g, ok := c.groups[groupID]
+	if !ok {
+		return nil, ErrUnknownMember
+	}
+
+	m, ok := g.members[memberID]
+	if !ok {
+		return nil, ErrUnknownMember
+	}
+	m.lastHeartbeat = c.now()
+
+	// Deleted topics' partitions are taken away from
+	// the members without waiting for them to rejoin.
+	if c.subscribesDeleted(g) {
+		c.rebalance(g)
+	}
+
+	return g.assignment(memberID), nil
+}
+
+// Leave removes the member from the group and rebalances it.
+func (c *Coordinator) Leave(groupID, memberID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	g, ok := c.groups[groupID]
+	if !ok {
+		return ErrUnknownMember
+	}
+	if _, ok := g.members[memberID]; !ok {
+		return ErrUnknownMember
+	}
+
+	delete(g.members, memberID)
+	if len(g.members) == 0 {
+		delete(c.groups, groupID)
+		return nil
+	}
+
+	c.rebalance(g)
+	return nil
+}
+
+// expire removes members whose session has timed out from every group,
+// rebalancing the groups they left and removing the ones left empty.
+func (c *Coordinator) expire() {
+	deadline := c.now().Add(-c.Config.SessionTimeout)
+
+	for groupID, g := range c.groups {
+		var expired bool
+		for id, m := range g.members {
+			if m.lastHeartbeat.Before(deadline) {
+				delete(g.members, id)
+				expired = true
+			}
+		}
+
+		switch {
+		case len(g.members) == 0:
+			delete(c.groups, groupID)
+		case expired:
+			c.rebalance(g)
+		}
+	}
+}
+
+// rebalance starts a new generation, reassigning the partitions of every
+// subscribed topic between the group's members. Topics that no longer
+// exist are dropped from the members' subscriptions.
+func (c *Coordinator) rebalance(g *group) {
+	subscriptions := make(map[string][]string, len(g.members))
+	partitions := make(map[string]uint32)
+	deleted := make(map[string]bool)
+
+	for _, m := range g.members {
+		for _, topic := range m.topics {
+			if _, ok := partitions[topic]; ok || deleted[topic] {
+				continue
+			}
+			n, err := c.Config.Partitions(topic)
+			if err != nil {
+				deleted[topic] = true
+				continue
+			}
+			partitions[topic] = n
+		}
+	}
+
+	for id, m := range g.members {
+		if len(deleted) > 0 {
+			m.topics = slices.DeleteFunc(m.topics, func(topic string) bool { return deleted[topic] })
+		}
+		subscriptions[id] = m.topics
+	}
+
+	assignments := g.strategy.assign()(subscriptions, partitions)
+	for id, m := range g.members {
+		m.assignment = assignments[id]
+	}
+	g.generation++
+}
+
+// subscribesDeleted returns whether any of the group's
+// members subscribes to a topic that no longer exists.
+func (c *Coordinator) subscribesDeleted(g *group) bool {
+	for _, m := range g.members {
+		for _, topic := range m.topics {
+			if _, err := c.Config.Partitions(topic); err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *group) assignment(memberID string) *Assignment {
+	return &Assignment{
+		MemberID:   memberID,
+		Generation: g.generation,
+		Partitions: g.members[memberID].assignment,
+	}
+}
+
+func newMemberID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "member-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,166 @@
+package group
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoordinator(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, c *Coordinator, clock *time.Time){
+		"join rebalances the group":         testJoin,
+		"leave rebalances the group":        testLeave,
+		"expired members are removed":       testExpire,
+		"unknown topics and members errors": testJoinErrors,
+		"strategy must match the group":     testStrategyMismatch,
+		"unknown strategies are rejected":   testUnknownStrategy,
+		"deleted topics are unsubscribed":   testDeletedTopic,
+		"empty groups are removed":          testEmptyGroups,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := NewCoordinator(Config{
+				SessionTimeout: time.Second,
+				Partitions: func(topic string) (uint32, error) {
+					if topic != "events" {
+						return 0, fmt.Errorf("topic not found")
+					}
+					return 4, nil
+				},
+			})
+			clock := time.Now()
+			c.now = func() time.Time { return clock }
+			fn(t, c, &clock)
+		})
+	}
+}
+
+func testJoin(t *testing.T, c *Coordinator, clock *time.Time) {
+	a, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+	require.NotEmpty(t, a.MemberID)
+	require.Equal(t, uint64(1), a.Generation)
+	require.Len(t, a.Partitions, 4)
+
+	b, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), b.Generation)
+	require.Len(t, b.Partitions, 2)
+
+	// the first member learns about the rebalance from its heartbeat.
+	a, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), a.Generation)
+	require.Len(t, a.Partitions, 2)
+	require.NotEqual(t, a.Partitions, b.Partitions)
+}
+
+func testLeave(t *testing.T, c *Coordinator, clock *time.Time) {
+	a, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+	b, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Leave("workers", b.MemberID))
+	require.Equal(t, ErrUnknownMember, c.Leave("workers", b.MemberID))
+
+	a, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), a.Generation)
+	require.Len(t, a.Partitions, 4)
+
+	// groups are dropped once empty.
+	require.NoError(t, c.Leave("workers", a.MemberID))
+	require.Empty(t, c.groups)
+}
+
+func testExpire(t *testing.T, c *Coordinator, clock *time.Time) {
+	a, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+	b, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+
+	*clock = clock.Add(700 * time.Millisecond)
+	_, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+
+	// b missed its heartbeat.
+	*clock = clock.Add(700 * time.Millisecond)
+	a, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+	require.Len(t, a.Partitions, 4)
+
+	_, err = c.Heartbeat("workers", b.MemberID)
+	require.Equal(t, ErrUnknownMember, err)
+}
+
+func testJoinErrors(t *testing.T, c *Coordinator, clock *time.Time) {
+	_, err := c.Join("workers", "", []string{"missing"}, Range)
+	require.Error(t, err)
+
+	_, err = c.Join("workers", "", nil, Range)
+	require.Equal(t, ErrNoTopics, err)
+
+	_, err = c.Join("workers", "member-unknown", []string{"events"}, Range)
+	require.Equal(t, ErrUnknownMember, err)
+	require.Empty(t, c.groups)
+}
+
+func testStrategyMismatch(t *testing.T, c *Coordinator, clock *time.Time) {
+	_, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+
+	_, err = c.Join("workers", "", []string{"events"}, RoundRobin)
+	require.ErrorIs(t, err, ErrInconsistentStrategy)
+}
+
+func testUnknownStrategy(t *testing.T, c *Coordinator, clock *time.Time) {
+	_, err := c.Join("workers", "", []string{"events"}, Strategy(7))
+	require.ErrorIs(t, err, ErrUnknownStrategy)
+	require.Empty(t, c.groups)
+}
+
+func testDeletedTopic(t *testing.T, c *Coordinator, clock *time.Time) {
+	topics := map[string]uint32{"events": 4, "logs": 2}
+	c.Config.Partitions = func(topic string) (uint32, error) {
+		n, ok := topics[topic]
+		if !ok {
+			return 0, fmt.Errorf("topic not found")
+		}
+		return n, nil
+	}
+
+	a, err := c.Join("workers", "", []string{"events", "logs"}, Range)
+	require.NoError(t, err)
+	b, err := c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+
+	delete(topics, "logs")
+	a, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+	require.Len(t, a.Partitions, 2)
+	require.Equal(t, "events", a.Partitions[0].Topic)
+
+	require.NoError(t, c.Leave("workers", b.MemberID))
+	a, err = c.Heartbeat("workers", a.MemberID)
+	require.NoError(t, err)
+	require.Len(t, a.Partitions, 4)
+	for _, tp := range a.Partitions {
+		require.Equal(t, "events", tp.Topic)
+	}
+	require.Equal(t, []string{"events"}, c.groups["workers"].members[a.MemberID].topics)
+}
+
+func testEmptyGroups(t *testing.T, c *Coordinator, clock *time.Time) {
+	_, err := c.Join("idle", "", []string{"events"}, Range)
+	require.NoError(t, err)
+
+	// every member of idle expires without anyone heartbeating it.
+	*clock = clock.Add(2 * time.Second)
+	_, err = c.Join("workers", "", []string{"events"}, Range)
+	require.NoError(t, err)
+
+	require.Len(t, c.groups, 1)
+	require.Contains(t, c.groups, "workers")
+}
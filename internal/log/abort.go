@@ -0,0 +1,121 @@
+package log
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+var (
+	// Length of an aborted transaction entry: the producer ID,
+	// the transaction's first offset and the abort marker's offset.
+	// These are all stored as uint64s
+	abortEntWidth uint64 = 24
+)
+
+// abortedTxn is a transaction that was aborted, every record its
+// producer appended from firstOffset up to the abort marker at
+// lastOffset belongs to it.
+type abortedTxn struct {
+	producerID  uint64
+	firstOffset uint64
+	lastOffset  uint64
+}
+
+// contains returns whether the producer's record at off was aborted.
+func (t abortedTxn) contains(producerID, off uint64) bool {
+	return t.producerID == producerID && t.firstOffset <= off && off < t.lastOffset
+}
+
+// abortIndex is the segment's list of aborted transactions, one entry
+// for each abort marker stored in the segment. Read committed consumers
+// use it to skip records from aborted transactions. Few segments ever
+// have an aborted transaction, so the index file is only created, and
+// kept open, once the first one is written to it. A missing file is an
+// empty index.
+type abortIndex struct {
+	name     string
+	file     *os.File
+	entries  []abortedTxn
+	readOnly bool
+}
+
+// newAbortIndex loads the aborted transactions stored in the file at name.
+func newAbortIndex(name string, readOnly bool) (*abortIndex, error) {
+	a := &abortIndex{name: name, readOnly: readOnly}
+	return a, a.load()
+}
+
+// load reads the entries written to the file since it was last loaded.
+func (a *abortIndex) load() error {
+	b, err := os.ReadFile(a.name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// A partially written entry at the end is from a crash mid write,
+	// its marker wasn't acknowledged so we can drop it. Read-only
+	// indexes leave it be, the writer may still be writing it.
+	n := uint64(len(b)) / abortEntWidth
+	if uint64(len(b)) != n*abortEntWidth && !a.readOnly {
+		if err = os.Truncate(a.name, int64(n*abortEntWidth)); err != nil {
+			return err
+		}
+	}
+
+	for i := uint64(len(a.entries)); i < n; i++ {
+		e := b[i*abortEntWidth : (i+1)*abortEntWidth]
+		a.entries = append(a.entries, abortedTxn{
+			producerID:  enc.Uint64(e[0:8]),
+			firstOffset: enc.Uint64(e[8:16]),
+			lastOffset:  enc.Uint64(e[16:24]),
+		})
+	}
+
+	return nil
+}
+
+// Write persists an aborted transaction to the index,
+// creating the index file if it's the first.
+func (a *abortIndex) Write(t abortedTxn) error {
+	if a.file == nil {
+		f, err := os.OpenFile(a.name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		a.file = f
+	}
+
+	e := make([]byte, abortEntWidth)
+	enc.PutUint64(e[0:8], t.producerID)
+	enc.PutUint64(e[8:16], t.firstOffset)
+	enc.PutUint64(e[16:24], t.lastOffset)
+
+	if _, err := a.file.Write(e); err != nil {
+		return err
+	}
+
+	a.entries = append(a.entries, t)
+	return nil
+}
+
+// Close flushes the index to stable storage and closes it.
+func (a *abortIndex) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// Name returns the abort index's file path
+func (a *abortIndex) Name() string {
+	return a.name
+}
@@ -0,0 +1,93 @@
+package log
+
+import (
+	api "github.com/masonictemple4/proglog/api/v1"
+)
+
+const (
+	// Segment sizes of a compacted log when the config leaves them zero.
+	compactedMaxStoreBytes = 1 << 20
+	compactedMaxIndexBytes = 1 << 20
+	// Fewest records appended since a log was last compacted
+	// before it's compacted again.
+	defaultCompactMin = 1000
+)
+
+// CompactedLog is a log of changes to some state, which is rebuilt by
+// replaying the log. Once more records were appended since the log was
+// last compacted than the state has entries, the whole state is appended
+// again and the records before it deleted. The log stays proportional to
+// the size of the state rather than growing with every change.
+type CompactedLog struct {
+	*Log
+
+	// CompactMin is the fewest records appended since the log was
+	// last compacted before it's compacted again.
+	CompactMin int
+	// Records appended since the log was last compacted.
+	appended int
+}
+
+// NewCompactedLog opens the compacted log stored in dir.
+func NewCompactedLog(dir string, c Config) (*CompactedLog, error) {
+	if c.Segment.MaxStoreBytes == 0 {
+		c.Segment.MaxStoreBytes = compactedMaxStoreBytes
+	}
+	if c.Segment.MaxIndexBytes == 0 {
+		c.Segment.MaxIndexBytes = compactedMaxIndexBytes
+	}
+
+	l, err := NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return &CompactedLog{Log: l, CompactMin: defaultCompactMin}, nil
+}
+
+// Replay calls fn with each record in the log, in order, stopping at
+// the first error.
+func (l *CompactedLog) Replay(fn func(record *api.Record) error) error {
+	lowest, err := l.LowestOffset()
+	if err != nil {
+		return err
+	}
+
+	it := l.Iterator(lowest)
+	for it.Next() {
+		if err = fn(it.Record()); err != nil {
+			return err
+		}
+		l.appended++
+	}
+	return it.Err()
+}
+
+// Append appends a change to the state.
+func (l *CompactedLog) Append(record *api.Record) (uint64, error) {
+	off, err := l.Log.Append(record)
+	if err == nil {
+		l.appended++
+	}
+	return off, err
+}
+
+// Compact compacts the log once more records were appended since it was
+// last compacted than both CompactMin and size, the number of entries in
+// the state. rewrite appends the whole state again, then the records
+// before it are deleted. If it fails part way the records left are
+// replayed before the appended ones, which hold the same state.
+func (l *CompactedLog) Compact(size int, rewrite func() error) error {
+	if l.appended < max(size, l.CompactMin) {
+		return nil
+	}
+
+	start, err := l.NextOffset()
+	if err != nil {
+		return err
+	}
+	l.appended = 0
+	if err = rewrite(); err != nil {
+		return err
+	}
+	return l.DeleteRecordsBefore(start)
+}
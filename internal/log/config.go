@@ -1,9 +1,43 @@
 package log
 
+import "time"
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		// IndexIntervalBytes makes the index sparse, only indexing a
+		// record once this many bytes were stored since the last one
+		// indexed. Reads scan forward from the nearest indexed record.
+		// Zero indexes every record.
+		IndexIntervalBytes uint64
+		// WideIndex stores relative offsets in index entries as uint64s
+		// rather than uint32s, so segments aren't rolled early to keep
+		// relative offsets from overflowing. It can only be set when
+		// the log is created.
+		WideIndex bool
+		// MaxAge rolls the active segment once its first record is
+		// older than this, so logs with few appends still have old
+		// segments to clean up. It's checked on appends, truncation
+		// and deleting records. Zero only rolls segments when full.
+		MaxAge time.Duration
 	}
+	// ProducerIdleTimeout is how long an idempotent producer's state is
+	// kept after its last append. Retries from a producer that's been
+	// forgotten aren't deduplicated. Defaults to 7 days.
+	ProducerIdleTimeout time.Duration
+	// WriteThrough writes every record through to the log's files
+	// before Append returns, rather than buffering records until the
+	// buffer fills up or the segment's rolled. It costs a write for
+	// every append, so only logs that are followed need it.
+	WriteThrough bool
+	// ReadOnly opens an existing log without modifying it, so it can
+	// be inspected or followed while another process writes to it.
+	// Read-only logs refuse writes and only see records appended
+	// since they were opened after a call to Refresh, and only those
+	// the writer has written through to its files. Writers followed
+	// this way should set WriteThrough so a Refresh after Append
+	// returns sees the record.
+	ReadOnly bool
 }
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package log
+
+// syncDir is a no-op, directories can only
+// be synced like files on unix.
+func syncDir(dir string) error {
+	return nil
+}
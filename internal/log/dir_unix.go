@@ -0,0 +1,16 @@
+//go:build unix
+
+package log
+
+import "os"
+
+// syncDir flushes dir's entries to disk, so files
+// created or renamed in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
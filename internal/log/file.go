@@ -0,0 +1,36 @@
+package log
+
+import (
+	"io/fs"
+	"os"
+	"path"
+)
+
+// WriteFileAtomic replaces the file at name with data. The data's written
+// to a temporary file and synced before it's renamed over name, then the
+// directory's synced so the rename is durable too. Whatever happens, name
+// holds either its old contents or data in full.
+func WriteFileAtomic(name string, data []byte, perm fs.FileMode) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(path.Dir(name))
+}
@@ -0,0 +1,33 @@
+package log
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := path.Join(dir, "file")
+
+	require.NoError(t, WriteFileAtomic(name, []byte("first"), 0644))
+	require.NoError(t, WriteFileAtomic(name, []byte("second"), 0644))
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), b)
+
+	// the temporary file doesn't outlive the write.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// failed writes leave the file as it was.
+	require.NoError(t, os.Mkdir(name+".tmp", 0755))
+	require.Error(t, WriteFileAtomic(name, []byte("third"), 0644))
+	b, err = os.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), b)
+}
@@ -2,7 +2,9 @@ package log
 
 import (
 	"io"
+	"math"
 	"os"
+	"sort"
 
 	"github.com/tysonmote/gommap"
 )
@@ -11,15 +13,23 @@ var (
 	// Length of offset record entries in our index.
 	// These are stored as uint32s
 	offWidth uint64 = 4
+	// Length of offset record entries in wide indexes.
+	// These are stored as uint64s
+	wideOffWidth uint64 = 8
 	// Length of position entry in our index.
 	// These are stored as uint64s
 	posWidth uint64 = 8
 	// Use to jump straight to the position
-	// of an entry given its offset.
+	// of an entry given its offset, when every
+	// offset has an entry in the index.
 	// (Position in file is: offset * entWidth)
 	entWidth = offWidth + posWidth
 )
 
+// Number of entries the index file grows by when it's
+// first written to, after which it doubles in size.
+const indexGrowEntries = 512
+
 // index defines our index file, which consists of a
 // persisted file and a memory mapped file.
 type index struct {
@@ -30,12 +40,27 @@ type index struct {
 	// Tells us the size of the index, and where to write
 	// the next.
 	size uint64
+	// Size the index file can grow up to.
+	maxBytes uint64
+	// Whether the index is mapped read-only.
+	readOnly bool
+	// Widths of the index's relative offsets and entries,
+	// wide indexes store relative offsets as uint64s.
+	offWidth, entWidth uint64
 }
 
 // creates an index for the given file.
 func newIndex(f *os.File, c Config) (*index, error) {
 	idx := &index{
-		file: f,
+		file:     f,
+		maxBytes: c.Segment.MaxIndexBytes,
+		readOnly: c.ReadOnly,
+		offWidth: offWidth,
+		entWidth: entWidth,
+	}
+	if c.Segment.WideIndex {
+		idx.offWidth = wideOffWidth
+		idx.entWidth = wideOffWidth + posWidth
 	}
 	fi, err := os.Stat(f.Name())
 	if err != nil {
@@ -44,27 +69,100 @@ func newIndex(f *os.File, c Config) (*index, error) {
 
 	idx.size = uint64(fi.Size())
 
-	// Grow file to max index size before memory-mapping.
-	// This can only be done once so we have to do it here.
-	if err = os.Truncate(f.Name(), int64(c.Segment.MaxIndexBytes)); err != nil {
-		return nil, err
+	// Read-only indexes are mapped as they are, the
+	// segment works out how many entries are valid.
+	if c.ReadOnly {
+		return idx, idx.remap()
 	}
 
-	// Memory map our index file.
-	if idx.mmap, err = gommap.Map(idx.file.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED); err != nil {
-		return nil, err
+	// Memory map our index file as it is, it's grown as
+	// entries are written. Empty files can't be mapped.
+	if idx.size > 0 {
+		if idx.mmap, err = gommap.Map(idx.file.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED); err != nil {
+			return nil, err
+		}
 	}
 
 	return idx, nil
 }
 
+// grow makes room for more entries by growing the index file, doubling
+// it up to the max index size, and mapping it again. The segment's lock
+// keeps readers from using the old mapping while it's replaced.
+func (i *index) grow() error {
+	n := uint64(len(i.mmap)) * 2
+	if n < indexGrowEntries*i.entWidth {
+		n = indexGrowEntries * i.entWidth
+	}
+	if n > i.maxBytes {
+		n = i.maxBytes
+	}
+	if n < i.size+i.entWidth {
+		return io.EOF
+	}
+
+	if err := i.file.Truncate(int64(n)); err != nil {
+		return err
+	}
+
+	if i.mmap != nil {
+		if err := i.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		i.mmap = nil
+	}
+
+	var err error
+	i.mmap, err = gommap.Map(i.file.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED)
+	return err
+}
+
+// remap maps the index file read-only at its current size, so a
+// read-only index sees entries another process writes to the file.
+func (i *index) remap() error {
+	fi, err := i.file.Stat()
+	if err != nil {
+		return err
+	}
+	if int64(len(i.mmap)) == fi.Size() {
+		return nil
+	}
+
+	if i.mmap != nil {
+		if err = i.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		i.mmap = nil
+	}
+
+	// Empty files can't be mapped.
+	if fi.Size() == 0 {
+		return nil
+	}
+
+	i.mmap, err = gommap.Map(i.file.Fd(), gommap.PROT_READ, gommap.MAP_SHARED)
+	return err
+}
+
 // Close ensures the memory-mapped file has synced it's data
 // with the persisted file and flushes the files contents to
 // stable storage. Finally truncating the persisted file
 // to how much data is actually in it.
+// Read-only indexes are unmapped and closed as they are.
 func (i *index) Close() error {
-	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
-		return err
+	if i.readOnly {
+		if i.mmap != nil {
+			if err := i.mmap.UnsafeUnmap(); err != nil {
+				return err
+			}
+		}
+		return i.file.Close()
+	}
+
+	if i.mmap != nil {
+		if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
+			return err
+		}
 	}
 	if err := i.file.Sync(); err != nil {
 		return err
@@ -75,49 +173,93 @@ func (i *index) Close() error {
 	return i.file.Close()
 }
 
-// Read takes an offset that is relative to the segment's base offset;
-// and returns the records associated position in the store.
-// Because we're using a relative offset we can store them as uint32s
-func (i *index) Read(in int64) (off uint32, pos uint64, err error) {
+// Read takes the number of an entry, which is the offset relative to the
+// segment's base offset when every offset has an entry, or -1 for the
+// last entry, and returns the entry's relative offset and the record's
+// associated position in the store.
+// Because we're using a relative offset we can store them as uint32s,
+// unless the index is wide.
+func (i *index) Read(in int64) (off uint64, pos uint64, err error) {
 	if i.size == 0 {
 		return 0, 0, io.EOF
 	}
 
 	// Check for last
+	n := uint64(in)
 	if in == -1 {
-		off = uint32((i.size / entWidth) - 1)
-	} else {
-		off = uint32(in)
+		n = i.size/i.entWidth - 1
 	}
 
-	// Set position to the given offset.
-	pos = uint64(off) * entWidth
-
-	if i.size < pos+entWidth {
+	if in < -1 || i.size/i.entWidth <= n {
 		return 0, 0, io.EOF
 	}
 
+	off, pos = i.entry(n * i.entWidth)
+	return off, pos, nil
+}
+
+// entry decodes the entry at byte e of the index's mapping.
+func (i *index) entry(e uint64) (off uint64, pos uint64) {
 	// Get offset
-	off = enc.Uint32(i.mmap[pos : pos+offWidth])
+	if i.offWidth == wideOffWidth {
+		off = enc.Uint64(i.mmap[e : e+i.offWidth])
+	} else {
+		off = uint64(enc.Uint32(i.mmap[e : e+i.offWidth]))
+	}
 	// Get position
-	pos = enc.Uint64(i.mmap[pos+offWidth : pos+entWidth])
+	pos = enc.Uint64(i.mmap[e+i.offWidth : e+i.entWidth])
+	return off, pos
+}
 
-	return off, pos, nil
+// find returns the last entry for a relative offset at or before off.
+// Entries are in offset order, but a sparse index doesn't have one for
+// every offset, so it's looked up by binary search unless the index has
+// the entry at its offset. Returns io.EOF when there's no such entry.
+func (i *index) find(off uint64) (uint64, uint64, error) {
+	n := i.size / i.entWidth
+
+	if off < n {
+		if o, pos, err := i.Read(int64(off)); err == nil && o == off {
+			return o, pos, nil
+		}
+	}
+
+	j := sort.Search(int(n), func(j int) bool {
+		o, _ := i.entry(uint64(j) * i.entWidth)
+		return o > off
+	})
+	if j == 0 {
+		return 0, 0, io.EOF
+	}
+	return i.Read(int64(j - 1))
+}
+
+// holds returns whether the index can store the relative offset off.
+func (i *index) holds(off uint64) bool {
+	return i.offWidth == wideOffWidth || off <= math.MaxUint32
 }
 
-// Write appends the given offset and position to the index.
-func (i *index) Write(off uint32, pos uint64) error {
+// Write appends the given offset and position to the index, growing
+// it if needed. Returns io.EOF once the index is at its max size.
+// The offset must fit the index's offset width, see holds.
+func (i *index) Write(off uint64, pos uint64) error {
 	// Do we have enough space?
-	if uint64(len(i.mmap)) < i.size+entWidth {
-		return io.EOF
+	if uint64(len(i.mmap)) < i.size+i.entWidth {
+		if err := i.grow(); err != nil {
+			return err
+		}
 	}
 
 	// Write the offset
-	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
+	if i.offWidth == wideOffWidth {
+		enc.PutUint64(i.mmap[i.size:i.size+i.offWidth], off)
+	} else {
+		enc.PutUint32(i.mmap[i.size:i.size+i.offWidth], uint32(off))
+	}
 	// Write the position.
-	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
+	enc.PutUint64(i.mmap[i.size+i.offWidth:i.size+i.entWidth], pos)
 
-	i.size += uint64(entWidth)
+	i.size += i.entWidth
 
 	return nil
 }
@@ -2,6 +2,7 @@ package log
 
 import (
 	"io"
+	"math"
 	"os"
 	"testing"
 
@@ -25,7 +26,7 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, f.Name(), idx.Name())
 
 	entries := []struct {
-		Off uint32
+		Off uint64
 		Pos uint64
 	}{
 		{Off: 0, Pos: 0},
@@ -53,7 +54,100 @@ func TestIndex(t *testing.T) {
 	require.NoError(t, err)
 	off, pos, err := idx.Read(-1)
 	require.NoError(t, err)
-	require.Equal(t, uint32(1), off)
+	require.Equal(t, uint64(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 
 }
+
+func TestIndexGrows(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "index_test")
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = (indexGrowEntries*2 + 1) * entWidth
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+
+	size := func() int64 {
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		return fi.Size()
+	}
+
+	// nothing is allocated until an entry's written.
+	require.Equal(t, int64(0), size())
+	require.NoError(t, idx.Write(0, 0))
+	require.Equal(t, int64(indexGrowEntries*entWidth), size())
+
+	// then it doubles, up to the max index size.
+	n := uint64(indexGrowEntries*2 + 1)
+	for off := uint64(1); off < n; off++ {
+		require.NoError(t, idx.Write(off, uint64(off)*10))
+	}
+	require.Equal(t, int64(c.Segment.MaxIndexBytes), size())
+	require.Equal(t, io.EOF, idx.Write(n, uint64(n)*10))
+
+	// entries written before growing are still there.
+	for off := uint64(0); off < n; off++ {
+		_, pos, err := idx.Read(int64(off))
+		require.NoError(t, err)
+		require.Equal(t, uint64(off)*10, pos)
+	}
+	require.NoError(t, idx.Close())
+}
+
+func TestIndexFind(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "index_test")
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+	defer idx.Close()
+
+	// a sparse index of records 0, 3 and 7.
+	for _, off := range []uint64{0, 3, 7} {
+		require.NoError(t, idx.Write(off, uint64(off)*10))
+	}
+
+	for off, want := range map[uint64]uint64{0: 0, 2: 0, 3: 3, 5: 3, 7: 7, 100: 7} {
+		got, pos, err := idx.find(off)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		require.Equal(t, uint64(want)*10, pos)
+	}
+}
+
+func TestWideIndex(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "index_test")
+	require.NoError(t, err)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.WideIndex = true
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+
+	// relative offsets past a uint32 are stored as they are.
+	big := uint64(math.MaxUint32) + 5
+	require.True(t, idx.holds(big))
+	require.NoError(t, idx.Write(0, 0))
+	require.NoError(t, idx.Write(big, 10))
+	require.Equal(t, uint64(2*(wideOffWidth+posWidth)), idx.size)
+
+	off, pos, err := idx.find(big + 1)
+	require.NoError(t, err)
+	require.Equal(t, big, off)
+	require.Equal(t, uint64(10), pos)
+	require.NoError(t, idx.Close())
+
+	c.Segment.WideIndex = false
+	f, err = os.CreateTemp(t.TempDir(), "index_test")
+	require.NoError(t, err)
+	idx, err = newIndex(f, c)
+	require.NoError(t, err)
+	defer idx.Close()
+	require.True(t, idx.holds(math.MaxUint32))
+	require.False(t, idx.holds(big))
+}
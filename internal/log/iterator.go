@@ -0,0 +1,79 @@
+package log
+
+import (
+	"fmt"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+)
+
+var ErrTruncated = fmt.Errorf("iterator position was truncated")
+
+// Iterator reads a log's records in offset order, crossing segments
+// as needed. It's safe to use while the log is appended to or truncated:
+// once it catches up with the end of the log Next returns false, and
+// calling Next again later picks up records appended since.
+//
+//	it := log.Iterator(0)
+//	for it.Next() {
+//		record := it.Record()
+//	}
+//	if err := it.Err(); err != nil {
+//		// handle err
+//	}
+type Iterator struct {
+	log    *Log
+	next   uint64
+	record *api.Record
+	err    error
+}
+
+// Iterator returns an iterator positioned at offset from.
+func (l *Log) Iterator(from uint64) *Iterator {
+	return &Iterator{log: l, next: from}
+}
+
+// Next reads the next record, returning false when the iterator has
+// caught up with the log or failed. A failed iterator stays failed,
+// if its position was truncated away Err returns ErrTruncated.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.log.mu.RLock()
+	defer it.log.mu.RUnlock()
+
+	if lowest := it.log.lowest(); it.next < lowest {
+		it.err = fmt.Errorf("%w: offset %d, lowest offset %d", ErrTruncated, it.next, lowest)
+		return false
+	}
+	if it.next >= it.log.activeSegment.next() {
+		return false
+	}
+
+	record, err := it.log.read(it.next)
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.record = record
+	// Offsets without a record are skipped.
+	it.next = record.Offset + 1
+	return true
+}
+
+// Record returns the record read by the last call to Next.
+func (it *Iterator) Record() *api.Record {
+	return it.record
+}
+
+// Offset returns the offset of the next record the iterator will read.
+func (it *Iterator) Offset() uint64 {
+	return it.next
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
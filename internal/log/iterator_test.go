@@ -0,0 +1,57 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterator(t *testing.T) {
+	dir, err := os.MkdirTemp("", "iterator-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+
+	it := log.Iterator(0)
+	var values []string
+	for it.Next() {
+		values = append(values, string(it.Record().Value))
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"record 0", "record 1", "record 2"}, values)
+	require.Equal(t, uint64(3), it.Offset())
+
+	// records appended after catching up are picked up.
+	_, err = log.Append(&api.Record{Value: []byte("record 3")})
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	require.Equal(t, uint64(3), it.Record().Offset)
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+
+	// truncating past an iterator's position is reported.
+	behind := log.Iterator(0)
+	require.NoError(t, log.Truncate(1))
+	require.False(t, behind.Next())
+	require.ErrorIs(t, behind.Err(), ErrTruncated)
+	require.False(t, behind.Next())
+
+	// iterators ahead of the truncation carry on.
+	_, err = log.Append(&api.Record{Value: []byte("record 4")})
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	require.Equal(t, []byte("record 4"), it.Record().Value)
+}
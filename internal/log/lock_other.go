@@ -0,0 +1,14 @@
+//go:build !unix
+
+package log
+
+import (
+	"os"
+	"path"
+)
+
+// lockDir creates the lock file in dir. Advisory locks are only
+// supported on unix, elsewhere nothing stops two logs opening dir.
+func lockDir(dir string) (*os.File, error) {
+	return os.OpenFile(path.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+)
+
+// lockDir takes an exclusive advisory lock on the lock file in dir,
+// failing with ErrLocked when another log holds it. The lock is
+// released when the returned file is closed, or the process exits.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(path.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, dir)
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
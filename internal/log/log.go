@@ -1,44 +1,76 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	api "github.com/masonictemple4/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
 )
 
+var (
+	ErrOffsetOutOfRange = fmt.Errorf("offset out of range")
+	ErrInvalidRecord    = fmt.Errorf("invalid record")
+	ErrReadOnly         = fmt.Errorf("log is read-only")
+	ErrLocked           = fmt.Errorf("log is in use by another process")
+	ErrInvalidSegments  = fmt.Errorf("invalid segments")
+)
+
+// Name of the file locked while a log is open, so two processes
+// can't write the same log.
+const lockFile = "lock"
+
 // Log is the abstraction that ties all of the segments together
 // This is our public interface for our library.
+//
+// Appends are serialized by writeMu and write the record under the
+// active segment's lock, mu is only held exclusively to change the
+// segments. So reads of the active segment only wait for the record
+// being written, and reads of sealed segments only wait for rolls.
 type Log struct {
-	mu sync.RWMutex
+	mu      sync.RWMutex
+	writeMu sync.Mutex
 
 	Dir    string
 	Config Config
 
 	activeSegment *segment
 	segments      []*segment
+
+	// Lock file held while the log is open for writing.
+	lock *os.File
+
+	// Format version, ID and config the log was created with.
+	meta *metadata
+
+	// Log start offset, records before it are deleted
+	// even if their segment is still around.
+	start uint64
+
+	// Recent appends and open transactions of idempotent producers.
+	producers *producers
+	// When idle producers were last looked for.
+	producersExpired time.Time
 }
 
 // NewLog returns a new log system stored in dir,
 // with constraints of c.
 //
 // Notes:
-// If max sizes are not specified we set the defaults
-// to 1024
+// If max sizes are not specified a new log defaults them
+// to 1024, an existing log uses the sizes it was created with.
+// The max index bytes and initial offset can't be changed
+// once the log is created.
 func NewLog(dir string, c Config) (*Log, error) {
-	if c.Segment.MaxStoreBytes == 0 {
-		c.Segment.MaxStoreBytes = 1024
-	}
-	if c.Segment.MaxIndexBytes == 0 {
-		c.Segment.MaxIndexBytes = 1024
-	}
-
 	l := &Log{
 		Dir:    dir,
 		Config: c,
@@ -47,37 +79,48 @@ func NewLog(dir string, c Config) (*Log, error) {
 	return l, l.setup()
 }
 
-func (l *Log) setup() error {
-	files, err := os.ReadDir(l.Dir)
-	if err != nil {
+func (l *Log) setup() (err error) {
+	// Read-only logs are meant to be opened while
+	// another process is writing, so don't lock.
+	if !l.Config.ReadOnly {
+		if l.lock, err = lockDir(l.Dir); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, s := range l.segments {
+			s.Close()
+		}
+		l.segments, l.activeSegment = nil, nil
+		if l.lock != nil {
+			l.lock.Close()
+		}
+	}()
+
+	if l.start, err = loadStartOffset(l.Dir); err != nil {
 		return err
 	}
 
-	var baseOffsets []uint64
+	baseOffsets, err := findSegments(l.Dir, l.start, l.Config.ReadOnly)
+	if err != nil {
+		return err
+	}
 
-	// Walk files and grab offsets
-	// TODO: Test this with a dir that has
-	// no files, and one with a file without an identifier.
-	for _, file := range files {
-		// TODO: Might want to consider the base log file with no segment
-		// identifier in the name as the active segment?
-		offStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
+	if l.Config.ReadOnly && len(baseOffsets) == 0 {
+		return fmt.Errorf("open %s: no segments: %w", l.Dir, fs.ErrNotExist)
+	}
+	if err = l.setupMeta(len(baseOffsets) == 0); err != nil {
+		return err
 	}
-	sort.Slice(baseOffsets, func(i, j int) bool {
-		return baseOffsets[i] < baseOffsets[j]
-	})
 
 	// Loop through offsets and create segments.
-	for i := 0; i < len(baseOffsets); i++ {
-		if err = l.newSegment(baseOffsets[i]); err != nil {
+	for _, off := range baseOffsets {
+		if err = l.newSegment(off); err != nil {
 			return err
 		}
-
-		// baseOffset contains dup for index and store
-		// so we skip the dup
-		i++
 	}
 
 	if l.segments == nil {
@@ -86,6 +129,75 @@ func (l *Log) setup() error {
 		}
 	}
 
+	// Each segment must start where the one before it ends. Only
+	// offsets before the log start offset may be missing, the log
+	// deleted them itself.
+	for i := 1; i < len(l.segments); i++ {
+		prev, s := l.segments[i-1], l.segments[i]
+		switch {
+		case prev.nextOffset < s.baseOffset && s.baseOffset > l.start:
+			return fmt.Errorf("%w in %s: offsets %d to %d are missing", ErrInvalidSegments, l.Dir, prev.nextOffset, s.baseOffset-1)
+		case prev.nextOffset > s.baseOffset:
+			return fmt.Errorf("%w in %s: segment %d overlaps segment %d", ErrInvalidSegments, l.Dir, prev.baseOffset, s.baseOffset)
+		}
+	}
+
+	// Only the last segment is ever appended to.
+	for _, s := range l.segments[:len(l.segments)-1] {
+		s.sealed = true
+	}
+
+	// The active segment rolls by the age of its first record.
+	if s := l.activeSegment; !l.Config.ReadOnly && s.nextOffset > s.baseOffset {
+		if s.created, err = s.firstAppended(); err != nil {
+			return err
+		}
+	}
+
+	return l.setupProducers()
+}
+
+// setupProducers restores the idempotent producer state from
+// its last snapshot, replaying any records appended after it.
+// Transactions left open stay open, it's up to whoever coordinates
+// them to end them.
+func (l *Log) setupProducers() error {
+	p, off, err := loadProducers(l.Dir)
+	if err != nil {
+		return err
+	}
+
+	next := l.activeSegment.nextOffset
+	// A snapshot past the end of the log is stale, rebuild it from scratch.
+	if off > next {
+		p, off = newProducers(), 0
+	}
+	if lowest := l.segments[0].baseOffset; off < lowest {
+		off = lowest
+	}
+
+	l.producers = p
+	for off < next {
+		record, err := l.read(off)
+		if err != nil {
+			return err
+		}
+		if record.Control == api.ControlType_CONTROL_TYPE_ABORT && !l.Config.ReadOnly {
+			if err = l.reindexAbort(record); err != nil {
+				return err
+			}
+		}
+		p.track(record, record.Offset)
+		off = record.Offset + 1
+	}
+
+	// The process writing a read-only log forgets its idle producers.
+	if l.Config.ReadOnly {
+		return nil
+	}
+	l.producersExpired = time.Time{}
+	l.expireProducers(time.Now())
+
 	return nil
 }
 
@@ -104,25 +216,136 @@ func (l *Log) newSegment(off uint64) error {
 }
 
 // Append method append s a record to the log. This will
-// also generate a new segment if aftwards the segment is
-// at max size per the config.
-// TODO - Optimization: Per segment mutex instead of log wide.
+// also generate a new segment if the active segment is older than
+// the max segment age, the record doesn't fit in the active segment,
+// or aftwards the segment is at max size per the config. Records too
+// large to fit in an empty segment are given a segment of their own.
+//
+// Records from idempotent producers (with a ProducerId) must carry
+// the producer's next sequence number. Appending a sequence the
+// producer recently appended returns its original offset instead.
 func (l *Log) Append(record *api.Record) (uint64, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	if l.Config.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	if record == nil {
+		return 0, fmt.Errorf("%w: record is nil", ErrInvalidRecord)
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if record.Control != api.ControlType_CONTROL_TYPE_NONE {
+		return 0, fmt.Errorf("%w: control records are appended by EndTransaction", ErrInvalidRecord)
+	}
+	if record.Transactional && record.ProducerId == 0 {
+		return 0, fmt.Errorf("%w: transactional records need a producer ID", ErrInvalidRecord)
+	}
+
+	if record.ProducerId != 0 {
+		off, dup, err := l.producers.check(record.ProducerId, record.Sequence)
+		if err != nil {
+			return 0, err
+		}
+		if dup {
+			return off, nil
+		}
+	}
+
+	return l.append(record)
+}
 
-	off, err := l.activeSegment.Append(record)
+// append writes the record to the active segment and
+// tracks its producer's state. The caller must hold l.writeMu.
+func (l *Log) append(record *api.Record) (uint64, error) {
+	if err := l.rollExpired(time.Now()); err != nil {
+		return 0, err
+	}
+	s := l.activeSegment
+
+	l.producers.begin(record, s.nextOffset)
+
+	off, err := s.Append(record)
+	// Roll to a new segment if the record doesn't fit in what's left of
+	// the active one. Empty segments take any record.
+	if errors.Is(err, errSegmentFull) {
+		if err = l.roll(s.nextOffset); err != nil {
+			return 0, err
+		}
+		s = l.activeSegment
+		off, err = s.Append(record)
+	}
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 
-	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(off + 1)
+	if record.Control == api.ControlType_CONTROL_TYPE_ABORT {
+		if err = l.indexAbort(s, record.ProducerId, off); err != nil {
+			return 0, err
+		}
+	}
+
+	l.producers.track(record, off)
+	l.expireProducers(time.Now())
+
+	// Read-only logs following this one see
+	// records once they're written through.
+	if l.Config.WriteThrough {
+		if err = s.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	if s.IsMaxed() {
+		err = l.roll(off + 1)
 	}
 	return off, err
 }
 
+// expireProducers forgets producers that have been idle for longer than
+// the config's timeout, looking for them at most once every interval.
+// The caller must hold l.writeMu.
+func (l *Log) expireProducers(now time.Time) {
+	if now.Sub(l.producersExpired) < producerExpireInterval {
+		return
+	}
+	l.producersExpired = now
+
+	timeout := l.Config.ProducerIdleTimeout
+	if timeout == 0 {
+		timeout = defaultProducerIdleTimeout
+	}
+	l.producers.expire(now.Add(-timeout).UnixMilli())
+}
+
+// rollExpired rolls the active segment once it's older than the max
+// segment age. It's checked when truncating and deleting records too,
+// so logs that are no longer appended to still roll and the records
+// they hold can be cleaned up. The caller must hold l.writeMu.
+func (l *Log) rollExpired(now time.Time) error {
+	if s := l.activeSegment; s.expired(now) {
+		return l.roll(s.nextOffset)
+	}
+	return nil
+}
+
+// roll seals the active segment and replaces it with a new
+// segment starting at off. The caller must hold l.writeMu.
+func (l *Log) roll(off uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.activeSegment.seal(); err != nil {
+		return err
+	}
+	return l.newSegment(off)
+}
+
 // The Read method reads the record stored at the given offset.
+// Segments may skip offsets, like when records are compacted away.
+// Reading an offset without a record returns the next record after
+// it, so callers should go by the returned record's offset.
 // TODO: Can this be further optmized? I doubt we'll have
 // that many log files. Most of the system logs I see are
 // < 10.
@@ -130,40 +353,121 @@ func (l *Log) Read(off uint64) (*api.Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
+	if lowest := l.lowest(); off < lowest {
+		return nil, fmt.Errorf("%w: %d. Log starts at %d.", ErrOffsetOutOfRange, off, lowest)
+	}
+
+	return l.read(off)
+}
+
+// read reads the record at off, even if it was deleted by moving the
+// log start offset past it. The caller must hold l.mu.
+func (l *Log) read(off uint64) (*api.Record, error) {
 	var s *segment
 
 	for _, segment := range l.segments {
-		if segment.baseOffset <= off && off < segment.nextOffset {
+		if segment.baseOffset <= off && off < segment.next() {
 			s = segment
 			break
 		}
 	}
 
-	if s == nil || s.nextOffset <= off {
-		return nil, fmt.Errorf("offset out of range: %d. Total %d segments.", off, len(l.segments))
+	if s == nil {
+		return nil, fmt.Errorf("%w: %d. Total %d segments.", ErrOffsetOutOfRange, off, len(l.segments))
 	}
 
 	return s.Read(off)
 }
 
+// ReadRange reads up to maxRecords records, and about maxBytes worth of
+// them, starting at from and crossing segments as needed. It returns the
+// records with the offset to read next. Zero limits are unlimited and at
+// least one record is returned when any are available, so callers always
+// make progress.
+func (l *Log) ReadRange(from uint64, maxRecords int, maxBytes uint64) ([]*api.Record, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.readRange(from, l.activeSegment.next(), maxRecords, maxBytes, nil)
+}
+
+// readRange reads the records from from up to end, leaving out any skip
+// returns true for. The caller must hold l.mu.
+func (l *Log) readRange(from, end uint64, maxRecords int, maxBytes uint64, skip func(*api.Record) bool) ([]*api.Record, uint64, error) {
+	if from < l.lowest() || from > l.activeSegment.next() {
+		return nil, from, fmt.Errorf("%w: %d. Total %d segments.", ErrOffsetOutOfRange, from, len(l.segments))
+	}
+
+	var records []*api.Record
+	var size uint64
+
+	off := from
+	for off < end {
+		if maxRecords > 0 && len(records) >= maxRecords {
+			break
+		}
+
+		// Offsets without a record read the next one.
+		record, err := l.read(off)
+		if err != nil {
+			return nil, from, err
+		}
+		if record.Offset >= end {
+			off = end
+			break
+		}
+		if skip != nil && skip(record) {
+			off = record.Offset + 1
+			continue
+		}
+
+		n := uint64(proto.Size(record))
+		if maxBytes > 0 && len(records) > 0 && size+n > maxBytes {
+			break
+		}
+		size += n
+		records = append(records, record)
+		off = record.Offset + 1
+	}
+
+	return records, off, nil
+}
+
 // The Close method will safely close our
 // log or return an error by looping
 // through and closing each segment.
+// The idempotent producer state is snapshotted
+// first so reopening doesn't have to rebuild it.
+// Finally the directory's lock is released.
 func (l *Log) Close() error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if !l.Config.ReadOnly {
+		if err := saveProducers(l.Dir, l.producers, l.activeSegment.nextOffset); err != nil {
+			return err
+		}
+	}
+
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
 
+	if l.lock != nil {
+		return l.lock.Close()
+	}
 	return nil
 }
 
 // The Remove method closes the log then removes its data
 func (l *Log) Remove() error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
 	if err := l.Close(); err != nil {
 		return err
 	}
@@ -177,23 +481,38 @@ func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
+	// Recreate the directory for the new log's lock and segments.
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	l.segments, l.activeSegment = nil, nil
 	return l.setup()
 }
 
 // LowestOffset is a helper method to make checking which nodes
-// have the oldest data.
+// have the oldest data. It's the log start offset once records
+// have been deleted with DeleteRecordsBefore.
 func (l *Log) LowestOffset() (uint64, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	return l.segments[0].baseOffset, nil
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.lowest(), nil
+}
+
+// NextOffset returns the offset the next record will be appended
+// at. Unlike HighestOffset it tells an empty log apart from one
+// with a single record.
+func (l *Log) NextOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.activeSegment.next(), nil
 }
 
 // HighestOffset is a helper method to make checking which nodes
 // have the newest data.
 func (l *Log) HighestOffset() (uint64, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	off := l.segments[len(l.segments)-1].nextOffset
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	off := l.segments[len(l.segments)-1].next()
 	if off == 0 {
 		return 0, nil
 	}
@@ -201,17 +520,46 @@ func (l *Log) HighestOffset() (uint64, error) {
 }
 
 // The Truncate method removes all segments whose highest offset
-// is lower than the lowest value passed in. This can be called periodically
-// to remove old segments whose data we (SHOULD) have processed and don't
-// need any longer.
+// is lower than or equal to the lowest value passed in. This can be called
+// periodically to remove old segments whose data we (SHOULD) have processed
+// and don't need any longer.
+//
+// The log always keeps an active segment. If the active segment's records
+// are all truncated it's replaced by an empty segment at the next offset,
+// so appends carry on from there and the highest offset never goes back.
+// Truncating past the highest offset is an ErrOffsetOutOfRange error.
 func (l *Log) Truncate(lowest uint64) error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if err := l.rollExpired(time.Now()); err != nil {
+		return err
+	}
+
+	if next := l.activeSegment.nextOffset; lowest >= next {
+		return fmt.Errorf("%w: can't truncate up to %d. Next offset %d.", ErrOffsetOutOfRange, lowest, next)
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if s := l.activeSegment; s.nextOffset <= lowest+1 && s.nextOffset > s.baseOffset {
+		if err := s.seal(); err != nil {
+			return err
+		}
+		if err := l.newSegment(s.nextOffset); err != nil {
+			return err
+		}
+	}
+
 	var segments []*segment
 
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
 			if err := s.Remove(); err != nil {
 				return err
 			}
@@ -225,12 +573,217 @@ func (l *Log) Truncate(lowest uint64) error {
 	return nil
 }
 
+// Refresh picks up the records appended to a read-only log by the
+// process writing it since the log was opened or last refreshed,
+// including any new segments, and drops segments it truncated away
+// or records it deleted. It's a no-op for logs that aren't read-only.
+func (l *Log) Refresh() error {
+	if !l.Config.ReadOnly {
+		return nil
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prev := l.activeSegment.nextOffset
+	if err := l.activeSegment.refresh(); err != nil {
+		return err
+	}
+
+	offsets, err := findSegments(l.Dir, l.start, true)
+	if err != nil {
+		return err
+	}
+	for _, off := range offsets {
+		if off <= l.activeSegment.baseOffset {
+			continue
+		}
+		// The writer rolled, so the active segment is complete. Pick
+		// up what it appended since it was refreshed and seal it.
+		if err = l.activeSegment.refresh(); err != nil {
+			return err
+		}
+		l.activeSegment.sealed = true
+		if err = l.newSegment(off); err != nil {
+			return err
+		}
+	}
+
+	if l.start, err = loadStartOffset(l.Dir); err != nil {
+		return err
+	}
+
+	for len(l.segments) > 1 {
+		_, err := os.Stat(l.segments[0].store.Name())
+		if !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err = l.segments[0].Close(); err != nil {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+
+	for off := prev; off < l.activeSegment.nextOffset; {
+		record, err := l.read(off)
+		if err != nil {
+			return err
+		}
+		l.producers.track(record, record.Offset)
+		off = record.Offset + 1
+	}
+
+	return nil
+}
+
+// segmentFiles are the files of a segment found in the log's directory.
+type segmentFiles struct {
+	store, index bool
+	// Whether the store has any records.
+	records bool
+}
+
+// findSegments returns the base offsets of the segments in dir, in
+// ascending order. Only files named like segment files are looked at,
+// anything else in the directory (the lock file, producer snapshot or
+// topic metadata) is left alone.
+//
+// Every segment needs its store and index, with two exceptions. The
+// process writing the log creates a new segment's files one by one, so a
+// read-only log skips an incomplete last segment, and a writable log
+// opens it as long as it has no records, creating the missing file. And
+// segments are removed from the front of the log, index first and store
+// last, so a segment without an index before the first complete one or
+// before the log start offset is one whose removal was interrupted. A writable log
+// finishes removing it, a read-only one skips it. Any other incomplete
+// segment is an ErrInvalidSegments error.
+func findSegments(dir string, start uint64, readOnly bool) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[uint64]*segmentFiles)
+	for _, e := range entries {
+		off, ext, ok := parseSegmentFile(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+
+		files, ok := found[off]
+		if !ok {
+			files = &segmentFiles{}
+			found[off] = files
+		}
+
+		switch ext {
+		case ".store":
+			fi, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			files.store = true
+			files.records = fi.Size() > 0
+		case ".index":
+			files.index = true
+		}
+	}
+
+	offsets := make([]uint64, 0, len(found))
+	for off := range found {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+
+	var (
+		segments []uint64
+		problems []string
+		complete bool
+	)
+	for i, off := range offsets {
+		files := found[off]
+		if files.store && files.index {
+			complete = true
+			segments = append(segments, off)
+			continue
+		}
+
+		if i == len(offsets)-1 {
+			if readOnly {
+				break
+			}
+			if !files.records {
+				segments = append(segments, off)
+				break
+			}
+		} else if !files.index && (!complete || offsets[i+1] <= start) {
+			if !readOnly {
+				if err = removeSegmentFiles(dir, off); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		switch {
+		case !files.store && !files.index:
+			problems = append(problems, fmt.Sprintf("segment %d has no store or index", off))
+		case !files.store:
+			problems = append(problems, fmt.Sprintf("segment %d has no store", off))
+		default:
+			problems = append(problems, fmt.Sprintf("segment %d has no index", off))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w in %s: %s", ErrInvalidSegments, dir, strings.Join(problems, ", "))
+	}
+
+	return segments, nil
+}
+
+// removeSegmentFiles removes what's left of the segment at off
+// in dir, in the order segment.Remove removes them.
+func removeSegmentFiles(dir string, off uint64) error {
+	for _, ext := range []string{".index", ".abort", ".store"} {
+		err := os.Remove(path.Join(dir, fmt.Sprintf("%d%s", off, ext)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSegmentFile returns the base offset and extension of a segment
+// file's name, or false if the name isn't a segment file's.
+func parseSegmentFile(name string) (uint64, string, bool) {
+	ext := path.Ext(name)
+	switch ext {
+	case ".store", ".index", ".abort":
+	default:
+		return 0, "", false
+	}
+
+	base := strings.TrimSuffix(name, ext)
+	off, err := strconv.ParseUint(base, 10, 64)
+	// Only names written the way segments name their files
+	// count, so "007.store" isn't mistaken for segment 7.
+	if err != nil || strconv.FormatUint(off, 10) != base {
+		return 0, "", false
+	}
+
+	return off, ext, true
+}
+
 // Reader returns an io.Reader to read the entire log.
 // We'll need this to implement coordinate consensus and
 // need to support snapshots and restoring logs.
 func (l *Log) Reader() io.Reader {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
 	readers := make([]io.Reader, len(l.segments))
 
@@ -1,9 +1,14 @@
 package log
 
 import (
+	"bytes"
 	"io"
+	"io/fs"
+	"math"
 	"os"
+	"path"
 	"testing"
+	"time"
 
 	api "github.com/masonictemple4/proglog/api/v1"
 	"github.com/stretchr/testify/require"
@@ -17,6 +22,16 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"idempotent producer dedup":         testIdempotentAppend,
+		"producer state survives restart":   testProducerRecovery,
+		"idle producers are forgotten":      testProducerExpiry,
+		"read range across segments":        testReadRange,
+		"read raw records across segments":  testReadRaw,
+		"read while appending":              testReadWhileAppending,
+		"read-only log follows the writer":  testReadOnly,
+		"open log locks its directory":      testLock,
+		"truncate keeps an active segment":  testTruncateAll,
+		"offsets missing from segments":     testOffsetGaps,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "store-test")
@@ -33,6 +48,9 @@ func TestLog(t *testing.T) {
 }
 
 func testAppendRead(t *testing.T, log *Log) {
+	_, err := log.Append(nil)
+	require.ErrorIs(t, err, ErrInvalidRecord)
+
 	obj := &api.Record{
 		Value: []byte("hello world"),
 	}
@@ -123,3 +141,1186 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+// Producer records are larger, their values are kept
+// short so they fit the test log's segments.
+func testIdempotentAppend(t *testing.T, log *Log) {
+	first, err := log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 0})
+	require.NoError(t, err)
+
+	second, err := log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 1})
+	require.NoError(t, err)
+
+	// retries return the original offsets.
+	off, err := log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 0})
+	require.NoError(t, err)
+	require.Equal(t, first, off)
+
+	off, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 1})
+	require.NoError(t, err)
+	require.Equal(t, second, off)
+
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, second, highest)
+
+	// gaps in the sequence are rejected.
+	_, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 3})
+	require.ErrorIs(t, err, ErrOutOfOrderSequence)
+
+	// producers don't share sequences.
+	_, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 8, Sequence: 0})
+	require.NoError(t, err)
+
+	// once out of the window old sequences can't be deduplicated.
+	for seq := uint64(2); seq < 2+producerWindow; seq++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: seq})
+		require.NoError(t, err)
+	}
+	_, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 0})
+	require.ErrorIs(t, err, ErrDuplicateSequence)
+}
+
+func testProducerRecovery(t *testing.T, log *Log) {
+	for seq := uint64(0); seq < 3; seq++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: seq})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// records appended after the snapshot are replayed.
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	off, err := n.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 3})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	require.NoError(t, n.Close())
+
+	require.NoError(t, os.Remove(path.Join(log.Dir, producerSnapshotFile)))
+
+	// without a snapshot the state is rebuilt from the records.
+	n, err = NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+
+	off, err = n.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	_, err = n.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 5})
+	require.ErrorIs(t, err, ErrOutOfOrderSequence)
+	require.NoError(t, n.Close())
+
+	// a torn snapshot is discarded and the state rebuilt from the records.
+	require.NoError(t, os.WriteFile(path.Join(log.Dir, producerSnapshotFile), []byte(`{"offset":`), 0644))
+	n, err = NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer n.Close()
+
+	off, err = n.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 3})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testProducerExpiry(t *testing.T, log *Log) {
+	_, err := log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 0})
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 8, Sequence: 0, Transactional: true})
+	require.NoError(t, err)
+
+	// not idle for long enough yet.
+	log.expireProducers(time.Now().Add(time.Hour))
+	_, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 5})
+	require.ErrorIs(t, err, ErrOutOfOrderSequence)
+
+	// forgotten producers can start again at any sequence, but
+	// producers with an open transaction are kept.
+	log.producersExpired = time.Time{}
+	log.expireProducers(time.Now().Add(defaultProducerIdleTimeout + time.Hour))
+	require.NotContains(t, log.producers.entries, uint64(7))
+	require.Contains(t, log.producers.entries, uint64(8))
+
+	_, err = log.Append(&api.Record{Value: []byte("hello"), ProducerId: 7, Sequence: 5})
+	require.NoError(t, err)
+}
+
+func testReadRange(t *testing.T, log *Log) {
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	// each record rolls to a new segment.
+	require.Greater(t, len(log.segments), 1)
+
+	records, next, err := log.ReadRange(0, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	require.Equal(t, uint64(5), next)
+	for i, record := range records {
+		require.Equal(t, uint64(i), record.Offset)
+	}
+
+	records, next, err = log.ReadRange(1, 3, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, uint64(1), records[0].Offset)
+	require.Equal(t, uint64(4), next)
+
+	// byte limits stop before the record that doesn't fit
+	// but always return at least one.
+	size := uint64(proto.Size(records[0]))
+	records, next, err = log.ReadRange(0, 0, size*2+1)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint64(2), next)
+
+	records, next, err = log.ReadRange(0, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, uint64(1), next)
+
+	// caught up.
+	records, next, err = log.ReadRange(5, 0, 0)
+	require.NoError(t, err)
+	require.Empty(t, records)
+	require.Equal(t, uint64(5), next)
+
+	_, _, err = log.ReadRange(6, 0, 0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+}
+
+func testReadRaw(t *testing.T, log *Log) {
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 1)
+
+	raw, next, err := log.ReadRaw(1, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 4, raw.Len)
+	require.Equal(t, uint64(5), next)
+
+	var buf bytes.Buffer
+	n, err := raw.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, raw.Size, n)
+
+	// the frames hold the stored records as is.
+	b := buf.Bytes()
+	for off := uint64(1); off < next; off++ {
+		size := enc.Uint64(b[:lenWidth])
+		record := &api.Record{}
+		require.NoError(t, proto.Unmarshal(b[lenWidth:lenWidth+size], record))
+
+		want, err := log.Read(off)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(want, record))
+
+		b = b[lenWidth+size:]
+	}
+	require.Empty(t, b)
+
+	b, err = raw.Bytes()
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), b)
+	require.NoError(t, raw.Close())
+
+	raw, next, err = log.ReadRaw(0, 2, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, raw.Len)
+	require.Equal(t, uint64(2), next)
+	require.NoError(t, raw.Close())
+
+	// byte limits always return at least one record.
+	raw, next, err = log.ReadRaw(0, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, raw.Len)
+	require.Equal(t, uint64(1), next)
+	require.NoError(t, raw.Close())
+
+	raw, next, err = log.ReadRaw(5, 0, 0)
+	require.NoError(t, err)
+	require.Zero(t, raw.Len)
+	require.Equal(t, uint64(5), next)
+	require.NoError(t, raw.Close())
+
+	_, _, err = log.ReadRaw(6, 0, 0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	// records that were read can be written out after
+	// their segments are deleted.
+	raw, _, err = log.ReadRaw(0, 0, 0)
+	require.NoError(t, err)
+	want, err := raw.Bytes()
+	require.NoError(t, err)
+	require.NoError(t, log.DeleteRecordsBefore(5))
+	buf.Reset()
+	n, err = raw.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, raw.Size, n)
+	require.Equal(t, want, buf.Bytes())
+	require.NoError(t, raw.Close())
+}
+
+func testReadWhileAppending(t *testing.T, log *Log) {
+	const n = 100
+
+	// The appender reports each offset as soon as it's appended,
+	// failing the test is left to the test's goroutine.
+	appended := make(chan uint64, n)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(appended)
+		for i := 0; i < n; i++ {
+			off, err := log.Append(&api.Record{Value: []byte("hello world")})
+			if err != nil {
+				errc <- err
+				return
+			}
+			appended <- off
+		}
+	}()
+
+	// every record is readable as soon as it's appended
+	// while the active segment is written to and rolled.
+	var read uint64
+	for off := range appended {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+		read++
+	}
+	select {
+	case err := <-errc:
+		require.NoError(t, err)
+	default:
+	}
+	require.Equal(t, uint64(n), read)
+}
+
+func testReadOnly(t *testing.T, log *Log) {
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	files := func() map[string]int64 {
+		entries, err := os.ReadDir(log.Dir)
+		require.NoError(t, err)
+		sizes := make(map[string]int64)
+		for _, e := range entries {
+			fi, err := e.Info()
+			require.NoError(t, err)
+			sizes[e.Name()] = fi.Size()
+		}
+		return sizes
+	}
+	before := files()
+
+	ro, err := NewLog(log.Dir, Config{ReadOnly: true})
+	require.NoError(t, err)
+
+	for off := uint64(0); off < 3; off++ {
+		record, err := ro.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello world"), record.Value)
+	}
+	highest, err := ro.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+
+	_, err = ro.Append(&api.Record{Value: []byte("hello world")})
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, ro.Truncate(0), ErrReadOnly)
+	require.ErrorIs(t, ro.EndTransaction(1, true), ErrReadOnly)
+
+	// opening the log read-only doesn't change any of its files.
+	require.NoError(t, ro.Close())
+	require.Equal(t, before, files())
+
+	ro, err = NewLog(log.Dir, Config{ReadOnly: true})
+	require.NoError(t, err)
+	defer ro.Close()
+
+	// appends show up once refreshed, across new segments.
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	_, err = ro.Read(4)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	require.NoError(t, ro.Refresh())
+	record, err := ro.Read(4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), record.Offset)
+
+	// segments the writer truncates are dropped.
+	require.NoError(t, log.Truncate(1))
+	require.NoError(t, ro.Refresh())
+	lowest, err := ro.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+
+	_, err = NewLog(t.TempDir(), Config{ReadOnly: true})
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func testLock(t *testing.T, log *Log) {
+	_, err := NewLog(log.Dir, log.Config)
+	require.ErrorIs(t, err, ErrLocked)
+
+	// read-only logs don't need the lock.
+	ro, err := NewLog(log.Dir, Config{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, ro.Close())
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+
+	// resetting the log takes the lock again.
+	require.NoError(t, log.Reset())
+	_, err = NewLog(log.Dir, log.Config)
+	require.ErrorIs(t, err, ErrLocked)
+	require.NoError(t, log.Close())
+}
+
+func TestRollover(t *testing.T) {
+	// records take 32 bytes, so two fit in a segment.
+	c := Config{}
+	c.Segment.MaxStoreBytes = 80
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// the third record didn't fit in the first segment.
+	require.Len(t, log.segments, 2)
+	require.Equal(t, uint64(2), log.activeSegment.baseOffset)
+	for _, s := range log.segments {
+		require.LessOrEqual(t, s.store.size, log.Config.Segment.MaxStoreBytes)
+	}
+
+	// a record larger than a segment gets one of its own.
+	big := make([]byte, 128)
+	off, err := log.Append(&api.Record{Value: big})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	require.Len(t, log.segments, 4)
+	require.Equal(t, uint64(4), log.activeSegment.baseOffset)
+
+	read, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, big, read.Value)
+
+	off, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+}
+
+func TestAppendLargeRecord(t *testing.T) {
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	// larger than the default segment size.
+	want := make([]byte, 4096)
+	off, err := log.Append(&api.Record{Value: want})
+	require.NoError(t, err)
+
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, want, read.Value)
+}
+
+func TestDeleteRecords(t *testing.T) {
+	// records take 32 bytes, so segments hold two.
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// segment 0 holds records 0 and 1, so it has to stay.
+	require.NoError(t, log.DeleteRecordsBefore(1))
+	require.Len(t, log.segments, 3)
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), lowest)
+	_, err = log.Read(0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	_, err = log.ReadCommitted(0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	_, _, err = log.ReadRange(0, 0, 0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	_, _, err = log.ReadRaw(0, 0, 0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	it := log.Iterator(0)
+	require.False(t, it.Next())
+	require.ErrorIs(t, it.Err(), ErrTruncated)
+	record, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), record.Offset)
+
+	// the start offset never moves back.
+	require.NoError(t, log.DeleteRecordsBefore(0))
+	lowest, err = log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), lowest)
+
+	// segment 0 is fully deleted now and removed.
+	require.NoError(t, log.DeleteRecordsBefore(3))
+	require.Len(t, log.segments, 2)
+	require.Equal(t, uint64(2), log.segments[0].baseOffset)
+
+	// the start offset survives restarts.
+	require.NoError(t, log.Close())
+	log, err = NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	lowest, err = log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+
+	// the active segment is kept even when all of it is deleted.
+	require.NoError(t, log.DeleteRecordsBefore(5))
+	require.Len(t, log.segments, 1)
+	_, err = log.Read(4)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+
+	require.ErrorIs(t, log.DeleteRecordsBefore(7), ErrOffsetOutOfRange)
+	require.NoError(t, log.Close())
+}
+
+func testTruncateAll(t *testing.T, log *Log) {
+	// there's nothing to truncate in an empty log.
+	require.ErrorIs(t, log.Truncate(0), ErrOffsetOutOfRange)
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	require.ErrorIs(t, log.Truncate(3), ErrOffsetOutOfRange)
+	require.ErrorIs(t, log.Truncate(math.MaxUint64), ErrOffsetOutOfRange)
+
+	// truncating every record leaves an empty active segment.
+	require.NoError(t, log.Truncate(2))
+	require.Len(t, log.segments, 1)
+	require.Equal(t, uint64(3), log.activeSegment.baseOffset)
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+	_, err = log.Read(2)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	// the empty active segment is kept.
+	require.ErrorIs(t, log.Truncate(3), ErrOffsetOutOfRange)
+	require.Len(t, log.segments, 1)
+
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer log.Close()
+
+	highest, err = log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), highest)
+	record, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), record.Offset)
+}
+
+func testOffsetGaps(t *testing.T, log *Log) {
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	// offset 3 is missing, like it was compacted away.
+	_, err := log.activeSegment.appendAt(&api.Record{Value: []byte("hello world")}, 4)
+	require.NoError(t, err)
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+
+	offsets := func(records []*api.Record) []uint64 {
+		var offs []uint64
+		for _, record := range records {
+			offs = append(offs, record.Offset)
+		}
+		return offs
+	}
+
+	check := func(log *Log) {
+		record, err := log.Read(3)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), record.Offset)
+		record, err = log.ReadCommitted(3)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), record.Offset)
+
+		records, next, err := log.ReadRange(0, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{0, 1, 2, 4, 5}, offsets(records))
+		require.Equal(t, uint64(6), next)
+
+		records, next, err = log.ReadRange(3, 1, 0)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{4}, offsets(records))
+		require.Equal(t, uint64(5), next)
+
+		raw, next, err := log.ReadRaw(0, 4, 0)
+		require.NoError(t, err)
+		require.Equal(t, 4, raw.Len)
+		require.Equal(t, uint64(5), next)
+		require.NoError(t, raw.Close())
+		raw, next, err = log.ReadRaw(3, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, 2, raw.Len)
+		require.Equal(t, uint64(6), next)
+		require.NoError(t, raw.Close())
+
+		var iterated []uint64
+		it := log.Iterator(0)
+		for it.Next() {
+			iterated = append(iterated, it.Record().Offset)
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, []uint64{0, 1, 2, 4, 5}, iterated)
+	}
+	check(log)
+
+	// reopening replays the producer state across the gap.
+	require.NoError(t, log.Close())
+	require.NoError(t, os.Remove(path.Join(log.Dir, producerSnapshotFile)))
+	log, err = NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer log.Close()
+	check(log)
+}
+
+func TestSegmentDiscovery(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, dir string, c Config){
+		"unrelated files are left alone":       testUnrelatedFiles,
+		"incomplete segments are reported":     testIncompleteSegments,
+		"gaps between segments are reported":   testSegmentGap,
+		"interrupted roll is recovered":        testInterruptedRoll,
+		"interrupted removal is finished":      testInterruptedRemoval,
+		"removal before the start offset":      testInterruptedDelete,
+		"incomplete last segment in read-only": testReadOnlyIncompleteSegment,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// each record fills a segment, segments 0 to 2
+			// hold records 0 to 2 and segment 3 is empty.
+			c := Config{}
+			c.Segment.MaxStoreBytes = 32
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				_, err := log.Append(&api.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			require.NoError(t, log.Close())
+
+			fn(t, dir, c)
+		})
+	}
+}
+
+func testUnrelatedFiles(t *testing.T, dir string, c Config) {
+	for _, name := range []string{"topic.json", "007.store", "foo.index", "3.txt", "4.store.bak"} {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte("not a segment"), 0644))
+	}
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	require.Len(t, log.segments, 4)
+	for off := uint64(0); off < 3; off++ {
+		_, err := log.Read(off)
+		require.NoError(t, err)
+	}
+}
+
+func testIncompleteSegments(t *testing.T, dir string, c Config) {
+	// only segment 1's abort index is left.
+	require.NoError(t, os.WriteFile(path.Join(dir, "1.abort"), nil, 0644))
+	require.NoError(t, os.Remove(path.Join(dir, "1.store")))
+	require.NoError(t, os.Remove(path.Join(dir, "1.index")))
+
+	_, err := NewLog(dir, c)
+	require.ErrorIs(t, err, ErrInvalidSegments)
+	require.ErrorContains(t, err, "segment 1 has no store or index")
+
+	// failing to open released the lock.
+	require.NoError(t, os.Remove(path.Join(dir, "1.abort")))
+	require.NoError(t, os.Remove(path.Join(dir, "2.index")))
+
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrInvalidSegments)
+	require.ErrorContains(t, err, "segment 2 has no index")
+}
+
+func testSegmentGap(t *testing.T, dir string, c Config) {
+	for _, ext := range []string{".store", ".index"} {
+		require.NoError(t, os.Remove(path.Join(dir, "1"+ext)))
+	}
+
+	_, err := NewLog(dir, c)
+	require.ErrorIs(t, err, ErrInvalidSegments)
+	require.ErrorContains(t, err, "offsets 1 to 1 are missing")
+
+	// offsets before the log start offset were deleted by the log.
+	require.NoError(t, saveStartOffset(dir, 2))
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Read(1)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	record, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), record.Offset)
+}
+
+func testInterruptedRoll(t *testing.T, dir string, c Config) {
+	// the empty last segment lost its index.
+	require.NoError(t, os.Remove(path.Join(dir, "3.index")))
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testInterruptedRemoval(t *testing.T, dir string, c Config) {
+	// segment 0 lost its index, but not its store.
+	require.NoError(t, os.Remove(path.Join(dir, "0.index")))
+
+	// read-only logs skip it.
+	ro, err := NewLog(dir, Config{ReadOnly: true})
+	require.NoError(t, err)
+	require.Len(t, ro.segments, 3)
+	require.NoError(t, ro.Close())
+	require.FileExists(t, path.Join(dir, "0.store"))
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	require.NoFileExists(t, path.Join(dir, "0.store"))
+	_, err = log.Read(0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	record, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), record.Offset)
+}
+
+func testInterruptedDelete(t *testing.T, dir string, c Config) {
+	// records before 2 were deleted, and segment 1's
+	// removal stopped after its index.
+	require.NoError(t, saveStartOffset(dir, 2))
+	require.NoError(t, os.Remove(path.Join(dir, "1.index")))
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	require.NoFileExists(t, path.Join(dir, "1.store"))
+	record, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), record.Offset)
+}
+
+func testReadOnlyIncompleteSegment(t *testing.T, dir string, c Config) {
+	// the writer is creating segment 4's files.
+	require.NoError(t, os.WriteFile(path.Join(dir, "4.store"), nil, 0644))
+
+	ro, err := NewLog(dir, Config{ReadOnly: true})
+	require.NoError(t, err)
+	defer ro.Close()
+	require.Len(t, ro.segments, 4)
+}
+
+func TestSegmentMaxAge(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 1)
+
+	// the segment's first record was appended over an hour ago.
+	log.activeSegment.created = time.Now().Add(-time.Hour)
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Len(t, log.segments, 2)
+	require.Equal(t, off, log.activeSegment.baseOffset)
+
+	// the age survives restarts.
+	first, err := log.Read(off)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	c.Segment.MaxAge = 20 * time.Millisecond
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Equal(t, time.UnixMilli(first.AppendTimestamp), log.activeSegment.created)
+
+	time.Sleep(c.Segment.MaxAge)
+	off, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Len(t, log.segments, 3)
+	require.Equal(t, off, log.activeSegment.baseOffset)
+
+	// idle logs roll when records are deleted, so
+	// the old active segment can be removed.
+	time.Sleep(c.Segment.MaxAge)
+	require.NoError(t, log.DeleteRecordsBefore(off+1))
+	require.Len(t, log.segments, 1)
+	require.Equal(t, off+1, log.activeSegment.baseOffset)
+}
+
+func TestSparseIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 4096
+	c.Segment.IndexIntervalBytes = 100
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		off, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+	}
+
+	// a record is indexed about every 100 bytes.
+	entries := log.activeSegment.index.size / entWidth
+	require.Less(t, entries, uint64(50/3))
+	require.Greater(t, entries, uint64(1))
+
+	read := func(log *Log, n uint64) {
+		for off := uint64(0); off < n; off++ {
+			record, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+		}
+		raw, next, err := log.ReadRaw(0, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, int(n), raw.Len)
+		require.Equal(t, n, next)
+		require.NoError(t, raw.Close())
+	}
+	read(log, 50)
+
+	// reopening counts the records after the last one indexed.
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	read(log, 50)
+
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(50), off)
+
+	// read-only logs count them too.
+	_, err = log.Read(50)
+	require.NoError(t, err)
+	ro, err := NewLog(dir, Config{ReadOnly: true})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), ro.Config.Segment.IndexIntervalBytes)
+	defer ro.Close()
+	read(ro, 51)
+}
+
+func TestRelativeOffsetOverflow(t *testing.T) {
+	for name, wide := range map[string]bool{"narrow index": false, "wide index": true} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			c := Config{}
+			c.Segment.WideIndex = wide
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			_, err = log.Append(&api.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+			// the last offset a narrow index can store.
+			last := uint64(math.MaxUint32)
+			_, err = log.activeSegment.appendAt(&api.Record{Value: []byte("hello world")}, last)
+			require.NoError(t, err)
+
+			off, err := log.Append(&api.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+			require.Equal(t, last+1, off)
+
+			if wide {
+				require.Len(t, log.segments, 1)
+			} else {
+				// the narrow index can't store the relative offset.
+				require.Len(t, log.segments, 2)
+				require.Equal(t, last+1, log.activeSegment.baseOffset)
+			}
+
+			for _, off := range []uint64{0, last, last + 1} {
+				record, err := log.Read(off)
+				require.NoError(t, err)
+				require.Equal(t, off, record.Offset)
+			}
+
+			// the index format is kept when reopening.
+			require.NoError(t, log.Close())
+			log, err = NewLog(dir, Config{})
+			require.NoError(t, err)
+			defer log.Close()
+			require.Equal(t, wide, log.Config.Segment.WideIndex)
+			record, err := log.Read(last + 1)
+			require.NoError(t, err)
+			require.Equal(t, last+1, record.Offset)
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, dir string, c Config){
+		"new log records its config":         testMetaCreated,
+		"reopening uses the original config": testMetaDefaults,
+		"config changes are rejected":        testMetaMismatch,
+		"segment sizes can change":           testMetaResize,
+		"newer formats are rejected":         testMetaFutureVersion,
+		"logs without metadata are migrated": testMetaMigrate,
+		"older formats are migrated":         testMetaMigrateVersion,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+
+			c := Config{}
+			c.Segment.MaxStoreBytes = 32
+			c.Segment.MaxIndexBytes = 120
+			c.Segment.InitialOffset = 5
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				_, err := log.Append(&api.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			require.NoError(t, log.Close())
+
+			fn(t, dir, c)
+		})
+	}
+}
+
+func testMetaCreated(t *testing.T, dir string, c Config) {
+	m, err := readMeta(dir)
+	require.NoError(t, err)
+	require.Equal(t, formatVersion, m.Version)
+	require.Len(t, m.ID, 32)
+	require.False(t, m.CreatedAt.IsZero())
+	require.Equal(t, metaConfig{MaxStoreBytes: 32, MaxIndexBytes: 120, InitialOffset: 5}, m.Config)
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Equal(t, m.ID, log.ID())
+}
+
+func testMetaDefaults(t *testing.T, dir string, c Config) {
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	require.Equal(t, c.Segment, log.Config.Segment)
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), lowest)
+}
+
+func testMetaMismatch(t *testing.T, dir string, c Config) {
+	changed := c
+	changed.Segment.InitialOffset = 10
+	_, err := NewLog(dir, changed)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+
+	changed = c
+	changed.Segment.WideIndex = true
+	_, err = NewLog(dir, changed)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+
+	changed = c
+	changed.Segment.IndexIntervalBytes = 64
+	_, err = NewLog(dir, changed)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+}
+
+func testMetaResize(t *testing.T, dir string, c Config) {
+	// larger segments fill the active segment's index
+	// past what the log was created with.
+	larger := c
+	larger.Segment.MaxStoreBytes = 1024
+	larger.Segment.MaxIndexBytes = 1024
+	log, err := NewLog(dir, larger)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// shrinking them again rolls the oversized active segment.
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(28), off)
+	require.Equal(t, off, log.segments[len(log.segments)-2].baseOffset)
+
+	for off := uint64(5); off <= 28; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+	}
+}
+
+func testMetaFutureVersion(t *testing.T, dir string, c Config) {
+	m, err := readMeta(dir)
+	require.NoError(t, err)
+	m.Version = formatVersion + 1
+	require.NoError(t, writeMeta(dir, m))
+
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func testMetaMigrate(t *testing.T, dir string, c Config) {
+	require.NoError(t, os.Remove(path.Join(dir, metaFile)))
+
+	// read-only logs are migrated without writing the metadata.
+	ro := c
+	ro.ReadOnly = true
+	rlog, err := NewLog(dir, ro)
+	require.NoError(t, err)
+	for off := uint64(5); off < 8; off++ {
+		_, err := rlog.Read(off)
+		require.NoError(t, err)
+	}
+	require.NoError(t, rlog.Close())
+	require.NoFileExists(t, path.Join(dir, metaFile))
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.NotEmpty(t, log.ID())
+	for off := uint64(5); off < 8; off++ {
+		_, err := log.Read(off)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	m, err := readMeta(dir)
+	require.NoError(t, err)
+	require.Equal(t, formatVersion, m.Version)
+	require.Equal(t, log.ID(), m.ID)
+	require.Equal(t, uint64(120), m.Config.MaxIndexBytes)
+}
+
+func testMetaMigrateVersion(t *testing.T, dir string, c Config) {
+	m, err := readMeta(dir)
+	require.NoError(t, err)
+	m.Version = 1
+	require.NoError(t, writeMeta(dir, m))
+
+	// read-only logs can't run migrations that change the log's files.
+	defer func(migration func(dir string) error) { migrations[1] = migration }(migrations[1])
+	migrations[1] = func(dir string) error { return nil }
+	ro := c
+	ro.ReadOnly = true
+	_, err = NewLog(dir, ro)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	migrated, err := readMeta(dir)
+	require.NoError(t, err)
+	require.Equal(t, formatVersion, migrated.Version)
+	require.Equal(t, m.ID, migrated.ID)
+	require.False(t, migrated.Config.WideIndex)
+}
+
+func TestReadOnlyVisibility(t *testing.T) {
+	dir := t.TempDir()
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+
+	ro, err := NewLog(dir, Config{ReadOnly: true})
+	require.NoError(t, err)
+	defer ro.Close()
+
+	// records are buffered by default.
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, ro.Refresh())
+	_, err = ro.Read(0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	require.NoError(t, log.Close())
+
+	// write through logs write them before Append returns.
+	log, err = NewLog(dir, Config{WriteThrough: true})
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, ro.Refresh())
+	record, err := ro.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), record.Value)
+}
+
+// Benchmarks reading sealed segments, and the active segment, while
+// records are appended concurrently. The appends made meanwhile are
+// reported too since they compete for the log.
+func BenchmarkReadWhileAppending(b *testing.B) {
+	for name, active := range map[string]bool{"sealed": false, "active": true} {
+		b.Run(name, func(b *testing.B) {
+			log := setupBenchmark(b)
+
+			appends := concurrently(b, func() error {
+				_, err := log.Append(&api.Record{Value: []byte("hello world")})
+				return err
+			})
+
+			b.RunParallel(func(pb *testing.PB) {
+				off := uint64(0)
+				for pb.Next() {
+					read := off % 1000
+					if active {
+						read, _ = log.HighestOffset()
+					}
+					if _, err := log.Read(read); err != nil {
+						b.Error(err)
+						return
+					}
+					off++
+				}
+			})
+
+			b.ReportMetric(float64(appends())/float64(b.N), "appends/op")
+		})
+	}
+}
+
+// Benchmarks appending while sealed segments are read concurrently.
+// The reads made meanwhile are reported too.
+func BenchmarkAppendWhileReading(b *testing.B) {
+	log := setupBenchmark(b)
+
+	off := uint64(0)
+	reads := concurrently(b, func() error {
+		off++
+		_, err := log.Read(off % 1000)
+		return err
+	})
+
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < b.N; i++ {
+		if _, err := log.Append(record); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(reads())/float64(b.N), "reads/op")
+}
+
+// setupBenchmark returns a log with 1000 records spread over sealed
+// segments, which is removed when the benchmark is done.
+func setupBenchmark(b *testing.B) *Log {
+	b.Helper()
+
+	dir, err := os.MkdirTemp("", "log-benchmark")
+	require.NoError(b, err)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64 << 10
+	c.Segment.MaxIndexBytes = 64 << 10
+	log, err := NewLog(dir, c)
+	require.NoError(b, err)
+	b.Cleanup(func() { require.NoError(b, log.Remove()) })
+
+	value := make([]byte, 1<<10)
+	for i := 0; i < 1000; i++ {
+		_, err := log.Append(&api.Record{Value: value})
+		require.NoError(b, err)
+	}
+
+	return log
+}
+
+// concurrently resets the benchmark's timer and calls fn in a loop
+// until the benchmark is done. The returned function stops the loop
+// and returns how many times fn was called.
+func concurrently(b *testing.B, fn func() error) func() int {
+	b.Helper()
+
+	stop := make(chan struct{})
+	calls := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stop:
+				calls <- n
+				return
+			default:
+			}
+			if err := fn(); err != nil {
+				b.Error(err)
+			}
+			n++
+		}
+	}()
+
+	b.ResetTimer()
+	return func() int {
+		b.StopTimer()
+		close(stop)
+		return <-calls
+	}
+}
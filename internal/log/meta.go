@@ -0,0 +1,228 @@
+package log
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	// Version of the log's on disk format. It's bumped whenever the
+	// format changes, along with a migration from the previous version.
+	formatVersion = 3
+	// Name of the file the log's metadata is stored in.
+	metaFile = "log.json"
+)
+
+var (
+	ErrUnsupportedVersion = fmt.Errorf("unsupported log format version")
+	ErrConfigMismatch     = fmt.Errorf("config doesn't match the log's")
+)
+
+// migrations upgrade a log's files from one format version to the
+// next, migrations[v] upgrades a log from version v to v+1. A nil
+// migration leaves the files as they are, only the version changes.
+var migrations = []func(dir string) error{
+	// Version 0 logs were written before the metadata file,
+	// otherwise their files are the same as version 1.
+	nil,
+	// Version 2 added wide indexes, version 1 logs keep
+	// their indexes as they are.
+	nil,
+	// Version 3 added sparse indexes and offset gaps, which older
+	// versions would look records up in wrong. Version 2 indexes
+	// index every record, so they read the same.
+	nil,
+}
+
+// metadata describes a log, it's written when the log's
+// created and checked every time it's opened.
+type metadata struct {
+	Version   int        `json:"version"`
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Config    metaConfig `json:"config"`
+}
+
+// metaConfig is the config the log was created with.
+type metaConfig struct {
+	MaxStoreBytes      uint64 `json:"max_store_bytes"`
+	MaxIndexBytes      uint64 `json:"max_index_bytes"`
+	InitialOffset      uint64 `json:"initial_offset"`
+	IndexIntervalBytes uint64 `json:"index_interval_bytes"`
+	WideIndex          bool   `json:"wide_index"`
+}
+
+// setupMeta loads the log's metadata, migrating the log first if it's
+// in an older format, or creates it for a new log. Segment config left
+// zero defaults to what the log was created with. The segment sizes may
+// change, indexes grow as they're written so their size is only a limit
+// and the active segment rolls if it's over it. The index format, its
+// interval and the initial offset can't change once the log is created,
+// existing indexes would be misread and the initial offset is only used
+// by new logs.
+func (l *Log) setupMeta(fresh bool) error {
+	m, err := readMeta(l.Dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && fresh:
+		l.setDefaults()
+		return l.createMeta()
+	case errors.Is(err, fs.ErrNotExist):
+		// Logs written before the metadata file are version 0.
+		l.setDefaults()
+		m = &metadata{Version: 0}
+	case err != nil:
+		return err
+	}
+
+	if m.Version > formatVersion {
+		return fmt.Errorf("%w: %d, newest supported is %d", ErrUnsupportedVersion, m.Version, formatVersion)
+	}
+
+	if m.Version < formatVersion {
+		if err = l.migrate(m); err != nil {
+			return err
+		}
+	}
+
+	c := &l.Config.Segment
+	if c.MaxStoreBytes == 0 {
+		c.MaxStoreBytes = m.Config.MaxStoreBytes
+	}
+	if c.MaxIndexBytes == 0 {
+		c.MaxIndexBytes = m.Config.MaxIndexBytes
+	}
+	if c.InitialOffset != 0 && c.InitialOffset != m.Config.InitialOffset {
+		return fmt.Errorf("%w: initial offset %d, log has %d", ErrConfigMismatch, c.InitialOffset, m.Config.InitialOffset)
+	}
+	c.InitialOffset = m.Config.InitialOffset
+	if c.WideIndex && !m.Config.WideIndex {
+		return fmt.Errorf("%w: wide index, log has a narrow one", ErrConfigMismatch)
+	}
+	c.WideIndex = m.Config.WideIndex
+	if c.IndexIntervalBytes != 0 && c.IndexIntervalBytes != m.Config.IndexIntervalBytes {
+		return fmt.Errorf("%w: index interval %d, log has %d", ErrConfigMismatch, c.IndexIntervalBytes, m.Config.IndexIntervalBytes)
+	}
+	c.IndexIntervalBytes = m.Config.IndexIntervalBytes
+
+	l.meta = m
+	return nil
+}
+
+// setDefaults fills in the segment config left zero. Logs keep
+// the sizes they're created with, so it only applies to new logs.
+func (l *Log) setDefaults() {
+	if l.Config.Segment.MaxStoreBytes == 0 {
+		l.Config.Segment.MaxStoreBytes = 1024
+	}
+	if l.Config.Segment.MaxIndexBytes == 0 {
+		l.Config.Segment.MaxIndexBytes = 1024
+	}
+}
+
+// createMeta writes a new log's metadata.
+func (l *Log) createMeta() error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	m := &metadata{
+		Version:   formatVersion,
+		ID:        hex.EncodeToString(id),
+		CreatedAt: time.Now().UTC(),
+		Config: metaConfig{
+			MaxStoreBytes:      l.Config.Segment.MaxStoreBytes,
+			MaxIndexBytes:      l.Config.Segment.MaxIndexBytes,
+			InitialOffset:      l.Config.Segment.InitialOffset,
+			IndexIntervalBytes: l.Config.Segment.IndexIntervalBytes,
+			WideIndex:          l.Config.Segment.WideIndex,
+		},
+	}
+	if err := writeMeta(l.Dir, m); err != nil {
+		return err
+	}
+
+	l.meta = m
+	return nil
+}
+
+// migrate upgrades the log's files from m's format version to the
+// current one, recording each version in the metadata as it's reached
+// so an interrupted migration picks up where it left off. Read-only logs
+// are migrated in memory, leaving their files be, as long as none of
+// the migrations change them.
+func (l *Log) migrate(m *metadata) error {
+	if m.Version == 0 {
+		// There's no record of how version 0 logs were
+		// created, so they keep the config they're opened with.
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		m.ID = hex.EncodeToString(id)
+		m.CreatedAt = time.Now().UTC()
+		m.Config = metaConfig{
+			MaxStoreBytes: l.Config.Segment.MaxStoreBytes,
+			MaxIndexBytes: l.Config.Segment.MaxIndexBytes,
+			InitialOffset: l.Config.Segment.InitialOffset,
+		}
+	}
+
+	for m.Version < formatVersion {
+		migration := migrations[m.Version]
+		if l.Config.ReadOnly {
+			if migration != nil {
+				return fmt.Errorf("%w: %d needs migrating to %d, open the log for writing first", ErrUnsupportedVersion, m.Version, formatVersion)
+			}
+			m.Version++
+			continue
+		}
+
+		if migration != nil {
+			if err := migration(l.Dir); err != nil {
+				return fmt.Errorf("migrating log from version %d: %w", m.Version, err)
+			}
+		}
+		m.Version++
+		if err := writeMeta(l.Dir, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ID returns the log's unique ID, generated when it was created.
+func (l *Log) ID() string {
+	return l.meta.ID
+}
+
+// readMeta loads the log's metadata from dir.
+func readMeta(dir string) (*metadata, error) {
+	b, err := os.ReadFile(path.Join(dir, metaFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &metadata{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", metaFile, err)
+	}
+	return m, nil
+}
+
+// writeMeta replaces the log's metadata in dir.
+func writeMeta(dir string, m *metadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path.Join(dir, metaFile), b, 0644)
+}
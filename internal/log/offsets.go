@@ -0,0 +1,147 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+)
+
+var ErrNoCommittedOffset = fmt.Errorf("no committed offset")
+
+// offsetKey identifies a consumer group's position in a partition.
+type offsetKey struct {
+	group     string
+	topic     string
+	partition uint32
+}
+
+// Offsets stores consumer groups' committed offsets. Every commit is
+// appended to an internal compacted log so the latest offsets can be
+// rebuilt by replaying it when the server restarts. Compacting appends
+// the latest offsets again, dropping the commits they replace.
+//
+// Each record's key is the group, topic and partition separated
+// by a NUL byte and its value is the big endian offset.
+type Offsets struct {
+	mu sync.RWMutex
+
+	log       *CompactedLog
+	committed map[offsetKey]uint64
+}
+
+// NewOffsets opens the offsets log stored in dir and replays
+// it to load the latest committed offsets.
+func NewOffsets(dir string, c Config) (*Offsets, error) {
+	l, err := NewCompactedLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Offsets{
+		log:       l,
+		committed: make(map[offsetKey]uint64),
+	}
+
+	return o, o.setup()
+}
+
+func (o *Offsets) setup() error {
+	err := o.log.Replay(func(record *api.Record) error {
+		key, err := decodeOffsetKey(record.Key)
+		if err != nil {
+			return err
+		}
+		if len(record.Value) != lenWidth {
+			return fmt.Errorf("invalid committed offset at %d", record.Offset)
+		}
+
+		o.committed[key] = enc.Uint64(record.Value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.compact()
+}
+
+// Commit persists offset as the group's position in the partition.
+func (o *Offsets) Commit(group, topic string, partition uint32, offset uint64) error {
+	key := offsetKey{group: group, topic: topic, partition: partition}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.append(key, offset); err != nil {
+		return err
+	}
+	o.committed[key] = offset
+
+	// The commit's persisted, compacting
+	// is retried with the next one.
+	o.compact()
+	return nil
+}
+
+func (o *Offsets) append(key offsetKey, offset uint64) error {
+	value := make([]byte, lenWidth)
+	enc.PutUint64(value, offset)
+
+	_, err := o.log.Append(&api.Record{Key: key.encode(), Value: value})
+	return err
+}
+
+// compact appends the latest offsets again once the log's due to be
+// compacted. The caller must hold o.mu or be setting the offsets up.
+func (o *Offsets) compact() error {
+	return o.log.Compact(len(o.committed), func() error {
+		for key, offset := range o.committed {
+			if err := o.append(key, offset); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Fetch returns the group's last committed offset in the partition.
+func (o *Offsets) Fetch(group, topic string, partition uint32) (uint64, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	off, ok := o.committed[offsetKey{group: group, topic: topic, partition: partition}]
+	if !ok {
+		return 0, ErrNoCommittedOffset
+	}
+	return off, nil
+}
+
+// Close closes the underlying offsets log.
+func (o *Offsets) Close() error {
+	return o.log.Close()
+}
+
+func (k offsetKey) encode() []byte {
+	return []byte(k.group + "\x00" + k.topic + "\x00" + strconv.FormatUint(uint64(k.partition), 10))
+}
+
+func decodeOffsetKey(b []byte) (offsetKey, error) {
+	parts := bytes.Split(b, []byte{0})
+	if len(parts) != 3 {
+		return offsetKey{}, fmt.Errorf("invalid offset key: %q", b)
+	}
+
+	p, err := strconv.ParseUint(string(parts[2]), 10, 32)
+	if err != nil {
+		return offsetKey{}, err
+	}
+
+	return offsetKey{
+		group:     string(parts[0]),
+		topic:     string(parts[1]),
+		partition: uint32(p),
+	}, nil
+}
@@ -0,0 +1,89 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsets(t *testing.T) {
+	dir, err := os.MkdirTemp("", "offsets-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+
+	o, err := NewOffsets(dir, c)
+	require.NoError(t, err)
+
+	_, err = o.Fetch("workers", "events", 0)
+	require.Equal(t, ErrNoCommittedOffset, err)
+
+	require.NoError(t, o.Commit("workers", "events", 0, 5))
+	require.NoError(t, o.Commit("workers", "events", 1, 7))
+	require.NoError(t, o.Commit("workers", "events", 0, 9))
+	require.NoError(t, o.Commit("billing", "events", 0, 2))
+
+	off, err := o.Fetch("workers", "events", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), off)
+
+	require.NoError(t, o.Close())
+
+	// offsets survive a restart.
+	o, err = NewOffsets(dir, c)
+	require.NoError(t, err)
+	defer o.Close()
+
+	for _, want := range []struct {
+		group     string
+		partition uint32
+		offset    uint64
+	}{
+		{"workers", 0, 9},
+		{"workers", 1, 7},
+		{"billing", 0, 2},
+	} {
+		off, err := o.Fetch(want.group, "events", want.partition)
+		require.NoError(t, err)
+		require.Equal(t, want.offset, off)
+	}
+}
+
+func TestOffsetsCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+
+	o, err := NewOffsets(dir, c)
+	require.NoError(t, err)
+	o.log.CompactMin = 4
+
+	for i := uint64(0); i < 100; i++ {
+		require.NoError(t, o.Commit("workers", "events", uint32(i%2), i))
+	}
+
+	// only the latest offsets and the commits since they were
+	// last appended are kept.
+	lowest, err := o.log.LowestOffset()
+	require.NoError(t, err)
+	next, err := o.log.NextOffset()
+	require.NoError(t, err)
+	require.LessOrEqual(t, next-lowest, uint64(o.log.CompactMin+len(o.committed)))
+	require.LessOrEqual(t, len(o.log.segments), o.log.CompactMin+len(o.committed)+1)
+	require.NoError(t, o.Close())
+
+	o, err = NewOffsets(dir, c)
+	require.NoError(t, err)
+	defer o.Close()
+
+	off, err := o.Fetch("workers", "events", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(98), off)
+	off, err = o.Fetch("workers", "events", 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(99), off)
+}
@@ -0,0 +1,235 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+)
+
+const (
+	// Number of recent appends remembered per producer. Retries of any
+	// of them are recognized as duplicates, so this bounds how many
+	// requests a producer may have in flight.
+	producerWindow = 5
+	// Name of the file the producer state is snapshotted to on close.
+	producerSnapshotFile = "producer.snapshot"
+	// How long a producer's state is kept after its last append
+	// when the config doesn't say.
+	defaultProducerIdleTimeout = 7 * 24 * time.Hour
+	// How often appends look for idle producers to forget.
+	producerExpireInterval = time.Minute
+)
+
+var (
+	ErrOutOfOrderSequence = fmt.Errorf("out of order sequence number")
+	ErrDuplicateSequence  = fmt.Errorf("sequence number is too old to deduplicate")
+)
+
+// sequenceEntry maps a producer's sequence number to the
+// offset and time in milliseconds the record was appended at.
+type sequenceEntry struct {
+	Sequence  uint64 `json:"sequence"`
+	Offset    uint64 `json:"offset"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// producers tracks the most recent appends of every idempotent
+// producer so retried appends can be deduplicated, along with
+// the producers' open transactions.
+type producers struct {
+	// Guards the state between the appending goroutine and
+	// read committed readers looking for open transactions.
+	mu sync.RWMutex
+
+	// Latest appends per producer ID, oldest first.
+	entries map[uint64][]sequenceEntry
+	// First offset of each producer's open transaction.
+	ongoing map[uint64]uint64
+}
+
+func newProducers() *producers {
+	return &producers{
+		entries: make(map[uint64][]sequenceEntry),
+		ongoing: make(map[uint64]uint64),
+	}
+}
+
+// track updates the state of the record's producer
+// after it was appended at off.
+func (p *producers) track(record *api.Record, off uint64) {
+	if record.ProducerId == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if record.Control != api.ControlType_CONTROL_TYPE_NONE {
+		delete(p.ongoing, record.ProducerId)
+		return
+	}
+
+	p.update(record.ProducerId, record.Sequence, off, record.AppendTimestamp)
+	if _, ok := p.ongoing[record.ProducerId]; record.Transactional && !ok {
+		p.ongoing[record.ProducerId] = off
+	}
+}
+
+// check returns the offset of the original append when the producer
+// already appended seq, or an error if seq can't be appended next.
+func (p *producers) check(id, seq uint64) (off uint64, dup bool, err error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entries := p.entries[id]
+	// New producers (or ones whose state was truncated away)
+	// may start at any sequence.
+	if len(entries) == 0 {
+		return 0, false, nil
+	}
+
+	last := entries[len(entries)-1]
+	if seq == last.Sequence+1 {
+		return 0, false, nil
+	}
+	if seq > last.Sequence {
+		return 0, false, fmt.Errorf("%w: got %d, expected %d", ErrOutOfOrderSequence, seq, last.Sequence+1)
+	}
+
+	for _, e := range entries {
+		if e.Sequence == seq {
+			return e.Offset, true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("%w: %d", ErrDuplicateSequence, seq)
+}
+
+// begin marks the transaction of a transactional record about to be
+// appended at off as open. It must be open before the record can be
+// read so read committed readers don't read past it. If the append
+// fails the transaction stays open until the producer ends it.
+func (p *producers) begin(record *api.Record, off uint64) {
+	if !record.Transactional {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.ongoing[record.ProducerId]; !ok {
+		p.ongoing[record.ProducerId] = off
+	}
+}
+
+// first returns the first offset of the producer's open transaction.
+func (p *producers) first(id uint64) (uint64, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	off, ok := p.ongoing[id]
+	return off, ok
+}
+
+// stable returns the first offset of the earliest open transaction,
+// or next when there's none.
+func (p *producers) stable(next uint64) uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, first := range p.ongoing {
+		if first < next {
+			next = first
+		}
+	}
+	return next
+}
+
+// update records that the producer appended seq at off at time ts.
+// The caller must hold p.mu.
+func (p *producers) update(id, seq, off uint64, ts int64) {
+	entries := append(p.entries[id], sequenceEntry{Sequence: seq, Offset: off, Timestamp: ts})
+	if len(entries) > producerWindow {
+		entries = entries[len(entries)-producerWindow:]
+	}
+	p.entries[id] = entries
+}
+
+// expire forgets producers that last appended before the time
+// in milliseconds, unless they still have a transaction open.
+// Forgotten producers may start again at any sequence.
+func (p *producers) expire(before int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, entries := range p.entries {
+		if _, ok := p.ongoing[id]; ok {
+			continue
+		}
+		if entries[len(entries)-1].Timestamp < before {
+			delete(p.entries, id)
+		}
+	}
+}
+
+// producerSnapshot is the on disk representation of
+// the producer state up to (not including) Offset.
+type producerSnapshot struct {
+	Offset    uint64                     `json:"offset"`
+	Producers map[uint64][]sequenceEntry `json:"producers"`
+	Ongoing   map[uint64]uint64          `json:"ongoing"`
+}
+
+// saveProducers snapshots the producer state so the next setup
+// only has to replay records appended after off.
+func saveProducers(dir string, p *producers, off uint64) error {
+	b, err := json.Marshal(producerSnapshot{Offset: off, Producers: p.entries, Ongoing: p.ongoing})
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path.Join(dir, producerSnapshotFile), b, 0644)
+}
+
+// loadProducers returns the snapshotted producer state and the offset
+// it's valid up to. A missing or unreadable snapshot is an empty state
+// from offset 0, so the state's rebuilt from every record in the log.
+func loadProducers(dir string) (*producers, uint64, error) {
+	b, err := os.ReadFile(path.Join(dir, producerSnapshotFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return newProducers(), 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var snap producerSnapshot
+	if err = json.Unmarshal(b, &snap); err != nil {
+		return newProducers(), 0, nil
+	}
+
+	// Snapshots written before appends were timestamped
+	// count as appended now, so they aren't expired early.
+	now := time.Now().UnixMilli()
+	p := newProducers()
+	for id, entries := range snap.Producers {
+		if len(entries) == 0 {
+			continue
+		}
+		for i := range entries {
+			if entries[i].Timestamp == 0 {
+				entries[i].Timestamp = now
+			}
+		}
+		p.entries[id] = entries
+	}
+	for id, first := range snap.Ongoing {
+		p.ongoing[id] = first
+	}
+	return p, snap.Offset, nil
+}
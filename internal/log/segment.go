@@ -1,22 +1,55 @@
 package log
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	api "github.com/masonictemple4/proglog/api/v1"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
+// Format version records are appended with.
+// Version 1 added keys, timestamps and headers.
+const recordVersion = 1
+
+// Field number of the record's offset, read from stored
+// records without decoding them.
+const recordOffsetField = 2
+
+// errSegmentFull is returned appending a record that
+// doesn't fit in the segment, so the log can roll.
+var errSegmentFull = errors.New("segment is full")
+
 // Segment wraps the `store` and `index` types
 // so that we may use them to read and write
-// to our log (active segment)
+// to our log (active segment). The abort index
+// lists the transactions aborted in the segment.
+//
+// While the segment is active mu guards its files and next offset, so
+// readers only wait on the write of a single record. Once the log rolls
+// to a new segment it's sealed, and since it's immutable from then on
+// it's read without locking.
 type segment struct {
+	mu     sync.RWMutex
+	sealed bool
+
 	store                  *store
 	index                  *index
+	abort                  *abortIndex
 	baseOffset, nextOffset uint64
 	config                 Config
+
+	// When the active segment's first record was
+	// appended, zero while it's empty.
+	created time.Time
 }
 
 func newSegment(dir string, baseOffset uint64, conf Config) (*segment, error) {
@@ -25,7 +58,12 @@ func newSegment(dir string, baseOffset uint64, conf Config) (*segment, error) {
 		config:     conf,
 	}
 
-	storeFile, err := os.OpenFile(path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	flag := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if conf.ReadOnly {
+		flag = os.O_RDONLY
+	}
+
+	storeFile, err := os.OpenFile(path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")), flag, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +72,7 @@ func newSegment(dir string, baseOffset uint64, conf Config) (*segment, error) {
 		return nil, err
 	}
 
-	indexFile, err := os.OpenFile(path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	indexFile, err := os.OpenFile(path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")), flag, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -43,45 +81,263 @@ func newSegment(dir string, baseOffset uint64, conf Config) (*segment, error) {
 		return nil, err
 	}
 
-	if off, _, err := s.index.Read(-1); err != nil {
-		s.nextOffset = baseOffset
-	} else {
-		s.nextOffset = baseOffset + uint64(off) + 1
+	if s.abort, err = newAbortIndex(path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".abort")), conf.ReadOnly); err != nil {
+		return nil, err
+	}
+
+	// Read-only segments count their records as they refresh.
+	if conf.ReadOnly {
+		s.nextOffset, s.index.size, s.store.size = baseOffset, 0, 0
+		return s, s.refresh()
+	}
+
+	// Find the last record, after the last one indexed.
+	s.nextOffset = baseOffset
+	if _, pos, err := s.index.Read(-1); err == nil {
+		last, _, err := s.scan(pos, s.store.size)
+		if err != nil {
+			return nil, err
+		}
+		if s.nextOffset, err = s.offsetAt(last); err != nil {
+			return nil, err
+		}
+		s.nextOffset++
 	}
 
 	return s, nil
 }
 
+// firstAppended returns when the segment's first record was appended.
+// Records written before append timestamps fall back to when the store
+// was last written, which is never earlier.
+func (s *segment) firstAppended() (time.Time, error) {
+	record, err := s.Read(s.baseOffset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if record.AppendTimestamp != 0 {
+		return time.UnixMilli(record.AppendTimestamp), nil
+	}
+
+	fi, err := s.store.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// refresh picks up the records another process appended to a read-only
+// segment since it was opened or last refreshed. The writer's index may
+// have entries for records still in its write buffer, or be zeroed past
+// its last entry, so entries only count while they're in order and point
+// into the store file. Records are then counted from the last entry up to
+// the last one that's completely in the store file. The segment must not
+// be read meanwhile.
+func (s *segment) refresh() (err error) {
+	// The writer shrinks its index file when it closes it, turn faults
+	// from reading past the file's end into errors instead of crashing.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("index %s changed while refreshing: %v", s.index.Name(), r)
+		}
+	}()
+
+	fi, err := s.store.Stat()
+	if err != nil {
+		return err
+	}
+	storeSize := uint64(fi.Size())
+
+	if err = s.index.remap(); err != nil {
+		return err
+	}
+	if err = s.abort.load(); err != nil {
+		return err
+	}
+
+	n := s.nextOffset - s.baseOffset
+	if n*s.index.entWidth > uint64(len(s.index.mmap)) {
+		return fmt.Errorf("index %s shrank while refreshing", s.index.Name())
+	}
+
+	// Entries must be in order with increasing offsets and positions,
+	// starting with the segment's first record at the store's start.
+	var lastOff, last uint64
+	if n > 0 {
+		lastOff, last, err = s.index.Read(int64(n - 1))
+		if err != nil {
+			return err
+		}
+	}
+	found := n
+	for ; (found+1)*s.index.entWidth <= uint64(len(s.index.mmap)); found++ {
+		off, pos := s.index.entry(found * s.index.entWidth)
+		if found == 0 && pos != 0 {
+			break
+		}
+		if found > 0 && (off <= lastOff || pos <= last) {
+			break
+		}
+		if pos+lenWidth > storeSize {
+			break
+		}
+		lastOff, last = off, pos
+	}
+	if found == 0 {
+		return nil
+	}
+
+	s.index.size = found * s.index.entWidth
+
+	lastRec, end, err := s.scan(last, storeSize)
+	if err != nil {
+		return err
+	}
+	// If the last indexed record isn't complete yet,
+	// the segment ends with the records before it.
+	next := s.baseOffset + lastOff
+	if end > last {
+		if next, err = s.offsetAt(lastRec); err != nil {
+			return err
+		}
+		next++
+	}
+
+	s.store.size = end
+	s.store.flushed.Store(end)
+	s.nextOffset = next
+	return nil
+}
+
+// scan walks the store's records from the one at pos up to the first
+// record that doesn't end within size bytes of the store. It returns
+// where the last complete record starts, and where it ends. Both are
+// pos if the record at pos isn't complete. Only records written through
+// to the file are scanned.
+func (s *segment) scan(pos, size uint64) (uint64, uint64, error) {
+	last, frame := pos, make([]byte, lenWidth)
+	for pos+lenWidth <= size {
+		if _, err := s.store.File.ReadAt(frame, int64(pos)); err != nil {
+			return 0, 0, err
+		}
+		end := pos + lenWidth + enc.Uint64(frame)
+		if end > size {
+			break
+		}
+		last, pos = pos, end
+	}
+	return last, pos, nil
+}
+
+// offsetAt returns the offset of the record stored at pos, without
+// decoding the rest of it.
+func (s *segment) offsetAt(pos uint64) (uint64, error) {
+	b, err := s.store.Read(pos)
+	if err != nil {
+		return 0, err
+	}
+	return recordOffset(b)
+}
+
+// recordOffset returns the offset field of an encoded record.
+func recordOffset(b []byte) (uint64, error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == recordOffsetField && typ == protowire.VarintType {
+			off, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			return off, nil
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	// Zero offsets aren't encoded.
+	return 0, nil
+}
+
 // Append writes the record segment and returns the newly appended
 // records offset. The log returns the records offset to the API response.
+// The record is stamped with the current format version and append time.
+// Returns errSegmentFull without writing if the record doesn't fit.
+// Only one goroutine may append at a time.
 func (s *segment) Append(record *api.Record) (offset uint64, err error) {
-	curOff := s.nextOffset
+	return s.appendAt(record, s.nextOffset)
+}
+
+// appendAt is Append with the record at offset off, which may skip
+// offsets after the segment's last record, leaving a gap. Segments
+// rewritten without some of their records, like compacted segments,
+// are written this way.
+func (s *segment) appendAt(record *api.Record, off uint64) (offset uint64, err error) {
+	if off < s.nextOffset {
+		return 0, fmt.Errorf("%w: can't append %d before the segment's next offset %d", ErrInvalidRecord, off, s.nextOffset)
+	}
+
+	curOff := off
 	record.Offset = curOff
+	record.Version = recordVersion
+	record.AppendTimestamp = time.Now().UnixMilli()
 
 	p, err := proto.Marshal(record)
 	if err != nil {
 		return 0, err
 	}
 
+	// Check the record fits before writing anything, so a full
+	// segment isn't left with a record that's missing from its index.
+	// Neither can the segment hold offsets too far past its base for
+	// the index to store.
+	indexed := s.indexes()
+	if !s.fits(uint64(len(p)), indexed) || !s.index.holds(curOff-s.baseOffset) {
+		return 0, errSegmentFull
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	_, pos, err := s.store.Append(p)
 	if err != nil {
 		return 0, err
 	}
 
-	relOff := uint32(s.nextOffset - uint64(s.baseOffset))
-	if err = s.index.Write(relOff, pos); err != nil {
-		return 0, err
+	if indexed {
+		if err = s.index.Write(curOff-s.baseOffset, pos); err != nil {
+			return 0, err
+		}
 	}
 
-	s.nextOffset++
+	if s.created.IsZero() {
+		s.created = time.UnixMilli(record.AppendTimestamp)
+	}
+
+	s.nextOffset = curOff + 1
 	return curOff, nil
 }
 
 // Read returns the record for the given offset. Similar to writes
 // to read a record the segment must first translate absolute index into
-// a relative offset and get the associated index entry.
+// a relative offset and get the associated index entry. If the segment
+// has no record at off, the next record after it is returned instead,
+// so callers go by the record's offset.
 func (s *segment) Read(off uint64) (*api.Record, error) {
-	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	if !s.sealed {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	pos, err := s.locate(off)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +353,140 @@ func (s *segment) Read(off uint64) (*api.Record, error) {
 	return record, err
 }
 
+// next returns the offset of the next record appended to the segment.
+func (s *segment) next() uint64 {
+	if !s.sealed {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	return s.nextOffset
+}
+
+// position returns where the record at off starts in the store, or
+// the end of the store when off is the segment's next offset.
+func (s *segment) position(off uint64) (uint64, error) {
+	if !s.sealed {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	if off == s.nextOffset {
+		return s.store.size, nil
+	}
+	return s.locate(off)
+}
+
+// locate returns where the first record at or after off starts in the
+// store. The index is looked up by offset since the segment may not have
+// a record at every offset, and a sparse index doesn't have an entry for
+// every record, so it scans forward through the store from the nearest
+// indexed record before off. The caller must hold s.mu unless the segment
+// is sealed.
+func (s *segment) locate(off uint64) (uint64, error) {
+	if off < s.baseOffset || off >= s.nextOffset {
+		return 0, io.EOF
+	}
+
+	rel := off - s.baseOffset
+	at, pos, err := s.index.find(rel)
+	// off is before the segment's first record.
+	if errors.Is(err, io.EOF) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	frame := make([]byte, lenWidth)
+	for at < rel {
+		if pos, err = s.store.end(pos, frame); err != nil {
+			return 0, err
+		}
+		recOff, err := s.offsetAt(pos)
+		if err != nil {
+			return 0, err
+		}
+		at = recOff - s.baseOffset
+	}
+	return pos, nil
+}
+
+// indexes returns whether the next record appended gets an index
+// entry: every record does unless the index interval makes the index
+// sparse, then only once enough bytes were stored since the last entry.
+// Only the goroutine appending may call it.
+func (s *segment) indexes() bool {
+	interval := s.config.Segment.IndexIntervalBytes
+	if interval == 0 {
+		return true
+	}
+	_, last, err := s.index.Read(-1)
+	if err != nil {
+		return true
+	}
+	return s.store.size-last >= interval
+}
+
+// writeAbort adds an aborted transaction to the segment's abort index.
+func (s *segment) writeAbort(t abortedTxn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.abort.Write(t)
+}
+
+// aborted returns whether the producer's record at off was part of a
+// transaction aborted in this segment.
+func (s *segment) aborted(producerID, off uint64) bool {
+	if !s.sealed {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	for _, t := range s.abort.entries {
+		if t.contains(producerID, off) {
+			return true
+		}
+	}
+	return false
+}
+
+// seal writes the segment's buffered records through to its files and
+// marks it immutable. The log must not be read while it's sealed.
+func (s *segment) seal() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.sealed = true
+	return nil
+}
+
+// flush writes the segment's buffered records through to its store
+// file, where other processes following the log can read them.
+// Only the goroutine appending may call it.
+func (s *segment) flush() error {
+	return s.store.flushTo(s.store.size)
+}
+
+// fits returns whether a record of n bytes, indexed or not, can be
+// appended without going over the segment's max store or index size.
+func (s *segment) fits(n uint64, indexed bool) bool {
+	// Records larger than a segment get one of their own.
+	if s.store.size == 0 {
+		return true
+	}
+	if indexed && s.index.size+s.index.entWidth > s.config.Segment.MaxIndexBytes {
+		return false
+	}
+	return s.store.size+lenWidth+n <= s.config.Segment.MaxStoreBytes
+}
+
+// expired returns whether the segment's first record was appended
+// longer than the max segment age ago. Empty segments never expire.
+func (s *segment) expired(now time.Time) bool {
+	maxAge := s.config.Segment.MaxAge
+	return maxAge > 0 && !s.created.IsZero() && now.Sub(s.created) >= maxAge
+}
+
 // IsMaxed returns wether the segment has reached its max size.
 // Either the store or index.
 // Can be used to know it needs to create a new segment.
@@ -104,7 +494,10 @@ func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes || s.index.size >= s.config.Segment.MaxIndexBytes
 }
 
-// Remove closes the segment and removes the index and store files.
+// Remove closes the segment and removes the index, abort and store
+// files. The store goes last, so a segment whose removal is interrupted
+// is left without an index and the log finishes removing it when it's
+// opened again.
 func (s *segment) Remove() error {
 	if err := s.Close(); err != nil {
 		return err
@@ -113,11 +506,11 @@ func (s *segment) Remove() error {
 	if err := os.Remove(s.index.Name()); err != nil {
 		return err
 	}
-	if err := os.Remove(s.store.Name()); err != nil {
+	// Segments without aborted transactions have no abort index.
+	if err := os.Remove(s.abort.Name()); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
-
-	return nil
+	return os.Remove(s.store.Name())
 }
 
 // Close will gracefully shutdown the segment's index and
@@ -130,6 +523,10 @@ func (s *segment) Close() error {
 	if err := s.store.Close(); err != nil {
 		return err
 	}
+
+	if err := s.abort.Close(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -1,9 +1,9 @@
 package log
 
 import (
-	"io"
 	"os"
 	"testing"
+	"time"
 
 	api "github.com/masonictemple4/proglog/api/v1"
 	"github.com/stretchr/testify/require"
@@ -35,7 +35,7 @@ func TestSegment(t *testing.T) {
 	}
 
 	_, err = s.Append(want)
-	require.Equal(t, io.EOF, err)
+	require.Equal(t, errSegmentFull, err)
 
 	// maxed index
 	require.True(t, s.IsMaxed())
@@ -57,3 +57,94 @@ func TestSegment(t *testing.T) {
 	require.False(t, s.IsMaxed())
 
 }
+
+func TestSegmentRecordFields(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "segment-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	defer s.Remove()
+
+	want := &api.Record{
+		Value:     []byte("hello world"),
+		Key:       []byte("user-1"),
+		Timestamp: 1700000000000,
+		Headers: map[string]string{
+			"content-type": "text/plain",
+			"traceparent":  "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		},
+	}
+
+	before := time.Now().UnixMilli()
+	off, err := s.Append(want)
+	require.NoError(t, err)
+
+	got, err := s.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, want.Value, got.Value)
+	require.Equal(t, want.Key, got.Key)
+	require.Equal(t, want.Timestamp, got.Timestamp)
+	require.Equal(t, want.Headers, got.Headers)
+	require.Equal(t, uint32(recordVersion), got.Version)
+	require.GreaterOrEqual(t, got.AppendTimestamp, before)
+}
+
+func TestSegmentOffsetGaps(t *testing.T) {
+	for name, interval := range map[string]uint64{"dense index": 0, "sparse index": 64} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			c := Config{}
+			c.Segment.MaxStoreBytes = 1024
+			c.Segment.MaxIndexBytes = 1024
+			c.Segment.IndexIntervalBytes = interval
+
+			s, err := newSegment(dir, 10, c)
+			require.NoError(t, err)
+
+			// offsets 10, 11, 13, 17 and 18 were left out.
+			offsets := []uint64{12, 14, 15, 16, 19}
+			for _, off := range offsets {
+				got, err := s.appendAt(&api.Record{Value: []byte("hello world")}, off)
+				require.NoError(t, err)
+				require.Equal(t, off, got)
+			}
+			require.Equal(t, uint64(20), s.nextOffset)
+
+			_, err = s.appendAt(&api.Record{Value: []byte("hello world")}, 19)
+			require.ErrorIs(t, err, ErrInvalidRecord)
+
+			check := func(s *segment) {
+				// missing offsets read the next record.
+				for off, want := range map[uint64]uint64{10: 12, 12: 12, 13: 14, 16: 16, 17: 19, 19: 19} {
+					record, err := s.Read(off)
+					require.NoError(t, err)
+					require.Equal(t, want, record.Offset)
+				}
+				_, err = s.Read(20)
+				require.Error(t, err)
+			}
+			check(s)
+
+			// reopening finds the next offset from the last record.
+			require.NoError(t, s.Close())
+			s, err = newSegment(dir, 10, c)
+			require.NoError(t, err)
+			require.Equal(t, uint64(20), s.nextOffset)
+			check(s)
+			require.NoError(t, s.Close())
+
+			c.ReadOnly = true
+			s, err = newSegment(dir, 10, c)
+			require.NoError(t, err)
+			defer s.Close()
+			require.Equal(t, uint64(20), s.nextOffset)
+			check(s)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the file the log start offset is stored in.
+const startOffsetFile = "start-offset"
+
+// DeleteRecordsBefore logically deletes every record before off by
+// moving the log start offset up to it. Deleted records can't be read
+// anymore, and segments holding only deleted records are removed. The
+// active segment is kept even when all its records are deleted, so the
+// log keeps counting offsets from where it was. The log start offset
+// never moves back, deleting records before it is a no-op.
+func (l *Log) DeleteRecordsBefore(off uint64) error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if err := l.rollExpired(time.Now()); err != nil {
+		return err
+	}
+
+	if next := l.activeSegment.nextOffset; off > next {
+		return fmt.Errorf("%w: %d. Next offset %d.", ErrOffsetOutOfRange, off, next)
+	}
+	if off <= l.lowest() {
+		return nil
+	}
+
+	// Persist it first so deleted records never reappear after a crash.
+	if err := saveStartOffset(l.Dir, off); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.start = off
+
+	for len(l.segments) > 1 && l.segments[0].nextOffset <= off {
+		if err := l.segments[0].Remove(); err != nil {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+
+	return nil
+}
+
+// lowest returns the offset of the first record that can be read, the
+// log start offset or the first segment's base offset if that's higher.
+// The caller must hold l.mu or l.writeMu.
+func (l *Log) lowest() uint64 {
+	if base := l.segments[0].baseOffset; base > l.start {
+		return base
+	}
+	return l.start
+}
+
+// saveStartOffset persists the log start offset in dir. It must be
+// durable before the records before it are reported deleted, or they
+// could reappear after a crash.
+func saveStartOffset(dir string, off uint64) error {
+	return WriteFileAtomic(path.Join(dir, startOffsetFile), []byte(strconv.FormatUint(off, 10)), 0644)
+}
+
+// loadStartOffset returns the log start offset persisted in dir.
+// Logs that never had records deleted start at 0.
+func loadStartOffset(dir string) (uint64, error) {
+	b, err := os.ReadFile(path.Join(dir, startOffsetFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	off, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", startOffsetFile, err)
+	}
+	return off, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -24,6 +25,9 @@ type store struct {
 	mu   sync.Mutex
 	buf  *bufio.Writer
 	size uint64
+	// Bytes written through to the file. They're read
+	// straight from it without flushing the buffer.
+	flushed atomic.Uint64
 }
 
 func newStore(f *os.File) (*store, error) {
@@ -32,11 +36,13 @@ func newStore(f *os.File) (*store, error) {
 		return nil, err
 	}
 	size := uint64(fi.Size())
-	return &store{
+	s := &store{
 		File: f,
 		size: size,
 		buf:  bufio.NewWriter(f),
-	}, nil
+	}
+	s.flushed.Store(size)
+	return s, nil
 }
 
 // Append persists bytes passed to it in the store.
@@ -64,43 +70,75 @@ func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 	// Set the new position.
 	s.size += uint64(w)
 
+	// The buffer writes through to the file as it fills up.
+	s.flushed.Store(s.size - uint64(s.buf.Buffered()))
+
 	return uint64(w), pos, nil
 }
 
 // Read returns the record stored at the given position.
 func (s *store) Read(pos uint64) ([]byte, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Make sure any buffered data is written.
-	if err := s.buf.Flush(); err != nil {
-		return nil, err
-	}
-
 	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+	if _, err := s.ReadAt(size, int64(pos)); err != nil {
 		return nil, err
 	}
 
 	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
+	if _, err := s.ReadAt(b, int64(pos+lenWidth)); err != nil {
 		return nil, err
 	}
 
 	return b, nil
 }
 
+// end returns where the record stored at the given position ends,
+// which is where the record after it starts. The record's length is
+// read into frame, which must be lenWidth bytes, so callers walking
+// many records can reuse it.
+func (s *store) end(pos uint64, frame []byte) (uint64, error) {
+	if _, err := s.ReadAt(frame, int64(pos)); err != nil {
+		return 0, err
+	}
+	return pos + lenWidth + enc.Uint64(frame), nil
+}
+
+// open opens the store's file for reading with its first n bytes
+// written through. The file's read through a descriptor of its own, so
+// it stays readable after the store's closed or removed. The caller
+// must close it.
+func (s *store) open(n uint64) (*os.File, error) {
+	if err := s.flushTo(n); err != nil {
+		return nil, err
+	}
+	return os.Open(s.Name())
+}
+
 // Implements the io.ReaderAt on store. Reads len(p)
 // bytes into p beginning at the offset.
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
+	if err := s.flushTo(uint64(off) + uint64(len(p))); err != nil {
+		return 0, err
+	}
+
+	return s.File.ReadAt(p, off)
+}
+
+// flushTo makes sure the first n bytes of the store are written to
+// the file, only flushing the buffer when they haven't been already.
+// Reads of data that's been written through don't take the lock.
+func (s *store) flushTo(n uint64) error {
+	if n <= s.flushed.Load() {
+		return nil
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if err := s.buf.Flush(); err != nil {
-		return 0, err
+		return err
 	}
-
-	return s.File.ReadAt(p, off)
+	s.flushed.Store(s.size)
+	return nil
 }
 
 // Close persists any buffered data before
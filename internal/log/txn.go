@@ -0,0 +1,150 @@
+package log
+
+import (
+	"fmt"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+)
+
+// EndTransaction commits or aborts the producer's open transaction by
+// appending a control record marking its end. Aborted transactions are
+// also added to the abort index of the marker's segment. It's a no-op
+// when the producer has no records in an open transaction in this log.
+func (l *Log) EndTransaction(producerID uint64, commit bool) error {
+	if l.Config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if _, ok := l.producers.first(producerID); !ok {
+		return nil
+	}
+
+	return l.endTransaction(producerID, commit)
+}
+
+// endTransaction appends the producer's end of transaction marker.
+// The caller must hold l.writeMu.
+func (l *Log) endTransaction(producerID uint64, commit bool) error {
+	control := api.ControlType_CONTROL_TYPE_ABORT
+	if commit {
+		control = api.ControlType_CONTROL_TYPE_COMMIT
+	}
+
+	_, err := l.append(&api.Record{ProducerId: producerID, Control: control})
+	return err
+}
+
+// indexAbort adds the transaction the abort marker at off ends to the
+// abort index of s, the segment the marker was appended to. It's indexed
+// before the producer's transaction is closed, which moves the last stable
+// offset past its records, so read committed readers already know to skip
+// them. The caller must hold l.writeMu.
+func (l *Log) indexAbort(s *segment, producerID, off uint64) error {
+	first, ok := l.producers.first(producerID)
+	if !ok {
+		return nil
+	}
+	return s.writeAbort(abortedTxn{producerID: producerID, firstOffset: first, lastOffset: off})
+}
+
+// reindexAbort adds the transaction the abort marker ends to its segment's
+// abort index if it's missing, like when the process stopped between
+// appending the marker and indexing it. It must be called before the
+// marker's tracked, while the transaction is still open.
+func (l *Log) reindexAbort(record *api.Record) error {
+	var s *segment
+	for _, seg := range l.segments {
+		if seg.baseOffset <= record.Offset {
+			s = seg
+		}
+	}
+
+	for _, t := range s.abort.entries {
+		if t.producerID == record.ProducerId && t.lastOffset == record.Offset {
+			return nil
+		}
+	}
+	return l.indexAbort(s, record.ProducerId, record.Offset)
+}
+
+// LastStableOffset returns the offset of the first record in an open
+// transaction, or the next offset when there's none. Records from the
+// last stable offset on may still be aborted.
+func (l *Log) LastStableOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.lastStableOffset()
+}
+
+func (l *Log) lastStableOffset() uint64 {
+	return l.producers.stable(l.activeSegment.next())
+}
+
+// ReadCommitted returns the first record at or after off that isn't
+// a control record or part of an aborted transaction. Records past
+// the last stable offset are never returned since their transaction
+// may yet abort.
+func (l *Log) ReadCommitted(off uint64) (*api.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if lowest := l.lowest(); off < lowest {
+		return nil, fmt.Errorf("%w: %d. Log starts at %d.", ErrOffsetOutOfRange, off, lowest)
+	}
+
+	lso := l.lastStableOffset()
+	for off < lso {
+		record, err := l.read(off)
+		if err != nil {
+			return nil, err
+		}
+		if record.Offset >= lso {
+			break
+		}
+		if !l.uncommitted(record) {
+			return record, nil
+		}
+		off = record.Offset + 1
+	}
+
+	return nil, fmt.Errorf("%w: %d. Last stable offset %d.", ErrOffsetOutOfRange, off, lso)
+}
+
+// ReadCommittedRange is ReadRange for read committed consumers, it stops
+// at the last stable offset and leaves out the records ReadCommitted
+// skips. The returned offset is past any records that were left out.
+func (l *Log) ReadCommittedRange(from uint64, maxRecords int, maxBytes uint64) ([]*api.Record, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.readRange(from, l.lastStableOffset(), maxRecords, maxBytes, l.uncommitted)
+}
+
+// uncommitted returns whether the record should be hidden from
+// read committed consumers. The caller must hold l.mu.
+func (l *Log) uncommitted(record *api.Record) bool {
+	if record.Control != api.ControlType_CONTROL_TYPE_NONE {
+		return true
+	}
+	return record.Transactional && l.isAborted(record.ProducerId, record.Offset)
+}
+
+// isAborted returns whether the producer's record at off was part
+// of an aborted transaction. The caller must hold l.mu.
+func (l *Log) isAborted(producerID, off uint64) bool {
+	for _, s := range l.segments {
+		// Aborts are indexed with their marker, which
+		// always comes after the records it aborts.
+		if s.next() <= off {
+			continue
+		}
+		if s.aborted(producerID, off) {
+			return true
+		}
+	}
+	return false
+}
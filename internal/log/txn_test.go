@@ -0,0 +1,193 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactions(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, log *Log){
+		"open transactions hold back the last stable offset": testLastStableOffset,
+		"read committed skips aborted and control records":   testReadCommitted,
+		"aborts survive a restart":                           testAbortRecovery,
+		"read committed range":                               testReadCommittedRange,
+		"open transactions stay open on reopen":              testOpenOnReopen,
+		"missing aborts are reindexed on reopen":             testAbortReindex,
+		"control records can't be appended":                  testAppendControl,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "txn-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxStoreBytes = 64
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			fn(t, log)
+		})
+	}
+}
+
+func appendTxn(t *testing.T, log *Log, producerID, seq uint64, value string) uint64 {
+	t.Helper()
+	off, err := log.Append(&api.Record{
+		Value:         []byte(value),
+		ProducerId:    producerID,
+		Sequence:      seq,
+		Transactional: true,
+	})
+	require.NoError(t, err)
+	return off
+}
+
+func testLastStableOffset(t *testing.T, log *Log) {
+	_, err := log.Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), log.LastStableOffset())
+
+	first := appendTxn(t, log, 1, 0, "txn")
+	_, err = log.Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+	require.Equal(t, first, log.LastStableOffset())
+
+	// records past the last stable offset are hidden.
+	_, err = log.ReadCommitted(first)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	require.NoError(t, log.EndTransaction(1, true))
+	require.Equal(t, uint64(4), log.LastStableOffset())
+
+	record, err := log.ReadCommitted(first)
+	require.NoError(t, err)
+	require.Equal(t, []byte("txn"), record.Value)
+
+	// ending without an open transaction is a no-op.
+	require.NoError(t, log.EndTransaction(1, true))
+	require.Equal(t, uint64(4), log.LastStableOffset())
+}
+
+func testReadCommitted(t *testing.T, log *Log) {
+	appendTxn(t, log, 1, 0, "aborted")
+	appendTxn(t, log, 2, 0, "committed")
+	appendTxn(t, log, 1, 1, "aborted")
+	require.NoError(t, log.EndTransaction(1, false))
+	require.NoError(t, log.EndTransaction(2, true))
+	_, err := log.Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+
+	var values []string
+	for off := uint64(0); ; {
+		record, err := log.ReadCommitted(off)
+		if err != nil {
+			require.ErrorIs(t, err, ErrOffsetOutOfRange)
+			break
+		}
+		values = append(values, string(record.Value))
+		off = record.Offset + 1
+	}
+	require.Equal(t, []string{"committed", "plain"}, values)
+
+	// read uncommitted sees everything, control records included.
+	record, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, api.ControlType_CONTROL_TYPE_ABORT, record.Control)
+}
+
+func testAbortRecovery(t *testing.T, log *Log) {
+	// abort indexes are only created for aborted transactions.
+	appendTxn(t, log, 1, 0, "aborted")
+	aborts, err := filepath.Glob(filepath.Join(log.Dir, "*.abort"))
+	require.NoError(t, err)
+	require.Empty(t, aborts)
+
+	require.NoError(t, log.EndTransaction(1, false))
+	aborts, err = filepath.Glob(filepath.Join(log.Dir, "*.abort"))
+	require.NoError(t, err)
+	require.Len(t, aborts, 1)
+	require.NoError(t, log.Close())
+
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer n.Close()
+
+	_, err = n.ReadCommitted(0)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+}
+
+func testOpenOnReopen(t *testing.T, log *Log) {
+	appendTxn(t, log, 1, 0, "open")
+	require.Equal(t, uint64(0), log.LastStableOffset())
+	require.NoError(t, log.Close())
+
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer n.Close()
+
+	// the coordinator may have decided to commit it.
+	require.Equal(t, uint64(0), n.LastStableOffset())
+	require.NoError(t, n.EndTransaction(1, true))
+
+	record, err := n.ReadCommitted(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("open"), record.Value)
+}
+
+func testAbortReindex(t *testing.T, log *Log) {
+	appendTxn(t, log, 1, 0, "aborted")
+	require.NoError(t, log.EndTransaction(1, false))
+	_, err := log.Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// as if the process stopped before indexing the abort
+	// and snapshotting its producers.
+	aborts, err := filepath.Glob(filepath.Join(log.Dir, "*.abort"))
+	require.NoError(t, err)
+	for _, name := range aborts {
+		require.NoError(t, os.Truncate(name, 0))
+	}
+	require.NoError(t, os.Remove(log.Dir+"/"+producerSnapshotFile))
+
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer n.Close()
+
+	record, err := n.ReadCommitted(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plain"), record.Value)
+}
+
+func testAppendControl(t *testing.T, log *Log) {
+	_, err := log.Append(&api.Record{Control: api.ControlType_CONTROL_TYPE_COMMIT, ProducerId: 1})
+	require.ErrorIs(t, err, ErrInvalidRecord)
+
+	_, err = log.Append(&api.Record{Transactional: true})
+	require.ErrorIs(t, err, ErrInvalidRecord)
+}
+
+func testReadCommittedRange(t *testing.T, log *Log) {
+	appendTxn(t, log, 1, 0, "aborted")
+	_, err := log.Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+	require.NoError(t, log.EndTransaction(1, false))
+	appendTxn(t, log, 2, 0, "open")
+
+	records, next, err := log.ReadCommittedRange(0, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, []byte("plain"), records[0].Value)
+	// stops at the open transaction.
+	require.Equal(t, uint64(3), next)
+
+	require.NoError(t, log.EndTransaction(2, true))
+	records, next, err = log.ReadCommittedRange(next, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, []byte("open"), records[0].Value)
+	require.Equal(t, uint64(5), next)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/group"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errGroupsDisabled  = status.Error(codes.Unimplemented, "consumer groups are not enabled on this server")
+	errOffsetsDisabled = status.Error(codes.Unimplemented, "committed offsets are not enabled on this server")
+)
+
+// groupError maps coordinator errors to their gRPC status.
+func groupError(err error) error {
+	switch {
+	case errors.Is(err, group.ErrUnknownMember):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, group.ErrInconsistentStrategy):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, group.ErrNoTopics), errors.Is(err, group.ErrUnknownStrategy):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return topicError(err)
+}
+
+func apiTopicPartitions(tps []group.TopicPartition) []*api.TopicPartition {
+	res := make([]*api.TopicPartition, 0, len(tps))
+	for _, tp := range tps {
+		res = append(res, &api.TopicPartition{Topic: tp.Topic, Partition: tp.Partition})
+	}
+	return res
+}
@@ -2,17 +2,34 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/masonictemple4/proglog/internal/log"
 )
 
-func NewHTTPServer(addr string) *http.Server {
+// NewHTTPServer serves produce and consume requests for an in memory
+// log on /. When clog isn't nil its records are served straight from
+// its store files on /records, for consumers catching up in bulk.
+// GET /records takes the offset, max_records and max_bytes query
+// parameters and responds with the records in their stored framing:
+// each record's length as a big endian uint64 followed by its protobuf
+// encoding. The offset to read next is returned in the X-Next-Offset
+// header.
+func NewHTTPServer(addr string, clog RawLog) *http.Server {
 	srv := newHTTPServer()
 	r := mux.NewRouter()
 
 	r.HandleFunc("/", srv.handleProduce).Methods(http.MethodPost)
 	r.HandleFunc("/", srv.handleConsume).Methods(http.MethodGet)
+	if clog != nil {
+		r.HandleFunc("/records", func(w http.ResponseWriter, r *http.Request) {
+			handleRecords(w, r, clog)
+		}).Methods(http.MethodGet)
+	}
 	return &http.Server{
 		Addr:    addr,
 		Handler: r,
@@ -92,3 +109,69 @@ func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// RawLog is a log that can read its records in their stored framing.
+type RawLog interface {
+	ReadRaw(from uint64, maxRecords int, maxBytes uint64) (*log.RawRecords, uint64, error)
+}
+
+func handleRecords(w http.ResponseWriter, r *http.Request, clog RawLog) {
+	offset, err := queryUint(r, "offset", 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxRecords, err := queryUint(r, "max_records", 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxBytes, err := queryUint(r, "max_bytes", 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case maxBytes == 0:
+		maxBytes = defaultBatchBytes
+	case maxBytes > maxBatchBytes:
+		maxBytes = maxBatchBytes
+	}
+
+	raw, next, err := clog.ReadRaw(offset, int(maxRecords), maxBytes)
+	if errors.Is(err, log.ErrOffsetOutOfRange) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer raw.Close()
+
+	// A known length lets the response writer hand
+	// the store files to the connection as is.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(raw.Size, 10))
+	w.Header().Set("X-Next-Offset", strconv.FormatUint(next, 10))
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are sent, all we can do about a failed
+	// write is cut the response short.
+	raw.WriteTo(w)
+}
+
+// queryUint parses the request's name query parameter, which is zero
+// when it's missing.
+func queryUint(r *http.Request, name string, bitSize int) (uint64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return n, nil
+}
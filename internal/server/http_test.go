@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHTTPProduceConsume(t *testing.T) {
+	srv := NewHTTPServer(":0", nil)
+
+	want := Record{
+		Value:     []byte("hello world"),
+		Key:       []byte("user-1"),
+		Timestamp: 1700000000000,
+		Headers:   map[string]string{"content-type": "text/plain"},
+	}
+
+	body, err := json.Marshal(ProduceRequest{Record: want})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var produce ProduceResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&produce))
+
+	body, err = json.Marshal(ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var consume ConsumeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&consume))
+	require.Equal(t, want.Value, consume.Record.Value)
+	require.Equal(t, want.Key, consume.Record.Key)
+	require.Equal(t, want.Timestamp, consume.Record.Timestamp)
+	require.Equal(t, want.Headers, consume.Record.Headers)
+	require.NotZero(t, consume.Record.AppendTimestamp)
+}
+
+func TestHTTPRecords(t *testing.T) {
+	dir := t.TempDir()
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := clog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	srv := httptest.NewServer(NewHTTPServer(":0", clog).Handler)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/records?offset=1&max_records=5")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "3", res.Header.Get("X-Next-Offset"))
+
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	for off := uint64(1); off < 3; off++ {
+		n := binary.BigEndian.Uint64(b)
+		record := &api.Record{}
+		require.NoError(t, proto.Unmarshal(b[8:8+n], record))
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, []byte("hello world"), record.Value)
+		b = b[8+n:]
+	}
+	require.Empty(t, b)
+
+	res, err = http.Get(srv.URL + "/records?offset=4")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/records?offset=x")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"sync"
+	"time"
 )
 
 type Log struct {
@@ -20,6 +21,7 @@ func (c *Log) Append(record Record) (uint64, error) {
 	defer c.mu.Unlock()
 
 	record.Offset = uint64(len(c.records))
+	record.AppendTimestamp = time.Now().UnixMilli()
 	c.records = append(c.records, record)
 	return record.Offset, nil
 }
@@ -37,6 +39,12 @@ func (c *Log) Read(offset uint64) (Record, error) {
 type Record struct {
 	Value  []byte `json:"value"`
 	Offset uint64 `json:"offset"`
+	Key    []byte `json:"key,omitempty"`
+	// Producer supplied creation time in milliseconds since the epoch.
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Time the log appended the record in milliseconds since the epoch.
+	AppendTimestamp int64             `json:"append_timestamp"`
+	Headers         map[string]string `json:"headers,omitempty"`
 }
 
 var ErrOffsetNotFound = fmt.Errorf("offset not found")
@@ -2,15 +2,60 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
 
 	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/group"
+	"github.com/masonictemple4/proglog/internal/log"
+	"github.com/masonictemple4/proglog/internal/topic"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// MaxMessageBytes is the largest message the server sends or
+	// receives. It's gRPC's default receive limit, so clients dialing
+	// with default options can receive every response.
+	MaxMessageBytes = 4 << 20
+	// Batch size used when a ConsumeBatch request doesn't set one.
+	defaultBatchBytes = 1 << 20
+	// Largest batch we'll send, leaving headroom under the message
+	// limit for the response's framing. A batch always holds at least
+	// one record, so records are capped at this size too.
+	maxBatchBytes = MaxMessageBytes - 64<<10
+	// Field number of ConsumeBatchResponse's records.
+	recordsField = 1
 )
 
 type Config struct {
+	// CommitLog serves requests that don't name a topic.
 	CommitLog CommitLog
+	// Topics serves requests that name a topic, along with
+	// the admin RPCs. Topics are unavailable when nil.
+	Topics TopicRegistry
+	// Offsets stores consumer groups' committed offsets.
+	// Consumer groups are unavailable when nil.
+	Offsets OffsetStore
+	// Groups coordinates consumer group membership.
+	// Group coordination is unavailable when nil.
+	Groups GroupCoordinator
+	// Transactions coordinates producers' transactions.
+	// Transactions are unavailable when nil.
+	Transactions TransactionCoordinator
 }
 
+var errRecordRequired = status.Error(codes.InvalidArgument, "record is required")
+
+var errRecordProducerFields = status.Error(codes.InvalidArgument, "records can't set producer fields, set them on the request")
+
 var _ api.LogServer = (*grpcServer)(nil)
 
 type grpcServer struct {
@@ -26,29 +71,476 @@ func newgrpcServer(conf *Config) (*grpcServer, error) {
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	if req.Record == nil {
+		return nil, errRecordRequired
+	}
 
-	offset, err := s.CommitLog.Append(req.Record)
+	partition, err := s.partitionFor(req)
+	if err != nil {
+		return nil, err
+	}
 
+	clog, release, err := s.commitLog(req.Topic, partition)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	// Larger records couldn't be consumed.
+	if n := proto.Size(req.Record); n > maxBatchBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "record is %d bytes, records can be at most %d", n, maxBatchBytes)
+	}
+
+	// The record's producer fields are only ever set from the request,
+	// so appends can't open transactions the coordinator doesn't know
+	// about or write control records.
+	if r := req.Record; r.GetProducerId() != 0 || r.GetSequence() != 0 || r.GetTransactional() ||
+		r.GetControl() != api.ControlType_CONTROL_TYPE_NONE {
+		return nil, errRecordProducerFields
+	}
+	if req.ProducerId != 0 {
+		req.Record.ProducerId = req.ProducerId
+		req.Record.Sequence = req.Sequence
+	}
 
-	return &api.ProduceResponse{Offset: offset}, nil
+	if req.Transactional {
+		done, err := s.addToTransaction(req.ProducerId, req.Topic, partition)
+		if err != nil {
+			return nil, err
+		}
+		// The transaction can't end until the record's appended.
+		defer done()
+		req.Record.Transactional = true
+	}
+
+	offset, err := clog.Append(req.Record)
+
+	if err != nil {
+		return nil, produceError(err)
+	}
+
+	return &api.ProduceResponse{Offset: offset, Partition: partition}, nil
 }
 
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-
-	record, err := s.CommitLog.Read(req.Offset)
+	clog, release, err := s.commitLog(req.Topic, req.Partition)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	var record *api.Record
+	if req.Isolation == api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED {
+		record, err = clog.ReadCommitted(req.Offset)
+	} else {
+		record, err = clog.Read(req.Offset)
+	}
+	if err != nil {
+		return nil, readError(err)
+	}
 
 	return &api.ConsumeResponse{Record: record}, nil
 
 }
 
+func (s *grpcServer) ConsumeBatch(ctx context.Context, req *api.ConsumeBatchRequest) (*api.ConsumeBatchResponse, error) {
+	clog, release, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	maxBytes := req.MaxBytes
+	switch {
+	case maxBytes == 0:
+		maxBytes = defaultBatchBytes
+	case maxBytes > maxBatchBytes:
+		maxBytes = maxBatchBytes
+	}
+
+	if req.Isolation == api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED {
+		records, next, err := clog.ReadCommittedRange(req.Offset, int(req.MaxRecords), maxBytes)
+		if err != nil {
+			return nil, readError(err)
+		}
+		return &api.ConsumeBatchResponse{Records: records, NextOffset: next}, nil
+	}
+
+	// Read uncommitted batches are served from the stored
+	// records as is, without decoding and re-encoding them.
+	raw, next, err := clog.ReadRaw(req.Offset, int(req.MaxRecords), maxBytes)
+	if err != nil {
+		return nil, readError(err)
+	}
+	defer raw.Close()
+
+	records, err := encodeRecords(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &api.ConsumeBatchResponse{NextOffset: next}
+	// The encoded records are sent as the message's unknown fields,
+	// which are marshaled verbatim, so clients decode them
+	// as the response's records.
+	res.ProtoReflect().SetUnknown(records)
+	return res, nil
+}
+
+// encodeRecords re-frames stored records as the wire encoding of the
+// batch response's repeated records field. It's done in place, each
+// record's 8 byte length is replaced by a tag and varint length, which
+// are no longer for records under 2^48 bytes.
+func encodeRecords(raw *log.RawRecords) (protoreflect.RawFields, error) {
+	b, err := raw.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var w, r int
+	for r < len(b) {
+		if len(b)-r < 8 {
+			return nil, fmt.Errorf("truncated record frame")
+		}
+		n := binary.BigEndian.Uint64(b[r:])
+		if uint64(len(b)-r-8) < n {
+			return nil, fmt.Errorf("truncated record frame")
+		}
+		if n >= 1<<48 {
+			return nil, fmt.Errorf("record frame too large: %d bytes", n)
+		}
+		r += 8
+
+		w = len(protowire.AppendTag(b[:w], recordsField, protowire.BytesType))
+		w = len(protowire.AppendVarint(b[:w], n))
+		w += copy(b[w:], b[r:r+int(n)])
+		r += int(n)
+	}
+	return b[:w], nil
+}
+
+func (s *grpcServer) InitProducerId(ctx context.Context, req *api.InitProducerIdRequest) (*api.InitProducerIdResponse, error) {
+	id, err := newProducerID()
+	if err != nil {
+		return nil, err
+	}
+	return &api.InitProducerIdResponse{ProducerId: id}, nil
+}
+
+func (s *grpcServer) BeginTransaction(ctx context.Context, req *api.BeginTransactionRequest) (*api.BeginTransactionResponse, error) {
+	if s.Transactions == nil {
+		return nil, errTransactionsDisabled
+	}
+	if req.ProducerId == 0 {
+		return nil, errProducerIDRequired
+	}
+
+	if err := s.Transactions.Begin(req.ProducerId); err != nil {
+		return nil, transactionError(err)
+	}
+
+	return &api.BeginTransactionResponse{}, nil
+}
+
+func (s *grpcServer) EndTransaction(ctx context.Context, req *api.EndTransactionRequest) (*api.EndTransactionResponse, error) {
+	if s.Transactions == nil {
+		return nil, errTransactionsDisabled
+	}
+
+	if err := s.Transactions.End(req.ProducerId, req.Commit); err != nil {
+		return nil, transactionError(err)
+	}
+
+	return &api.EndTransactionResponse{}, nil
+}
+
+// addToTransaction adds the partition to the producer's transaction,
+// returning a func to call once the record's appended.
+func (s *grpcServer) addToTransaction(producerID uint64, topic string, partition uint32) (func(), error) {
+	if s.Transactions == nil {
+		return nil, errTransactionsDisabled
+	}
+	if producerID == 0 {
+		return nil, errProducerIDRequired
+	}
+
+	done, err := s.Transactions.Add(producerID, partitionKey(topic, partition))
+	if err != nil {
+		return nil, transactionError(err)
+	}
+	return done, nil
+}
+
+func (s *grpcServer) CreateTopic(ctx context.Context, req *api.CreateTopicRequest) (*api.CreateTopicResponse, error) {
+	if s.Topics == nil {
+		return nil, errTopicsDisabled
+	}
+
+	t, err := s.Topics.Create(req.Name, topicConfig(req.Config))
+	if err != nil {
+		return nil, topicError(err)
+	}
+
+	return &api.CreateTopicResponse{Topic: apiTopic(t)}, nil
+}
+
+func (s *grpcServer) ListTopics(ctx context.Context, req *api.ListTopicsRequest) (*api.ListTopicsResponse, error) {
+	if s.Topics == nil {
+		return nil, errTopicsDisabled
+	}
+
+	res := &api.ListTopicsResponse{}
+	for _, t := range s.Topics.List() {
+		res.Topics = append(res.Topics, apiTopic(t))
+	}
+
+	return res, nil
+}
+
+func (s *grpcServer) DeleteTopic(ctx context.Context, req *api.DeleteTopicRequest) (*api.DeleteTopicResponse, error) {
+	if s.Topics == nil {
+		return nil, errTopicsDisabled
+	}
+
+	if err := s.Topics.Delete(req.Name); err != nil {
+		return nil, topicError(err)
+	}
+
+	return &api.DeleteTopicResponse{}, nil
+}
+
+// DeleteRecords moves the partition's log start offset up to the
+// requested offset, deleting the records before it.
+func (s *grpcServer) DeleteRecords(ctx context.Context, req *api.DeleteRecordsRequest) (*api.DeleteRecordsResponse, error) {
+	clog, release, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err = clog.DeleteRecordsBefore(req.Offset); err != nil {
+		return nil, readError(err)
+	}
+
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.DeleteRecordsResponse{LowOffset: lowest}, nil
+}
+
+func (s *grpcServer) ListOffsets(ctx context.Context, req *api.ListOffsetsRequest) (*api.ListOffsetsResponse, error) {
+	clog, release, err := s.commitLog(req.Topic, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	next, err := clog.NextOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ListOffsetsResponse{
+		LowOffset:        lowest,
+		HighOffset:       next,
+		LastStableOffset: clog.LastStableOffset(),
+	}, nil
+}
+
+func (s *grpcServer) CommitOffset(ctx context.Context, req *api.CommitOffsetRequest) (*api.CommitOffsetResponse, error) {
+	if err := s.checkGroupRequest(req.Group, req.Topic, req.Partition); err != nil {
+		return nil, err
+	}
+
+	if err := s.Offsets.Commit(req.Group, req.Topic, req.Partition, req.Offset); err != nil {
+		return nil, err
+	}
+
+	return &api.CommitOffsetResponse{}, nil
+}
+
+func (s *grpcServer) FetchOffset(ctx context.Context, req *api.FetchOffsetRequest) (*api.FetchOffsetResponse, error) {
+	if err := s.checkGroupRequest(req.Group, req.Topic, req.Partition); err != nil {
+		return nil, err
+	}
+
+	off, err := s.Offsets.Fetch(req.Group, req.Topic, req.Partition)
+	if err == log.ErrNoCommittedOffset {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.FetchOffsetResponse{Offset: off}, nil
+}
+
+func (s *grpcServer) JoinGroup(ctx context.Context, req *api.JoinGroupRequest) (*api.JoinGroupResponse, error) {
+	if s.Groups == nil {
+		return nil, errGroupsDisabled
+	}
+	if req.Group == "" {
+		return nil, status.Error(codes.InvalidArgument, "group is required")
+	}
+
+	a, err := s.Groups.Join(req.Group, req.MemberId, req.Topics, group.Strategy(req.Strategy))
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	return &api.JoinGroupResponse{
+		MemberId:    a.MemberID,
+		Generation:  a.Generation,
+		Assignments: apiTopicPartitions(a.Partitions),
+	}, nil
+}
+
+func (s *grpcServer) Heartbeat(ctx context.Context, req *api.HeartbeatRequest) (*api.HeartbeatResponse, error) {
+	if s.Groups == nil {
+		return nil, errGroupsDisabled
+	}
+
+	a, err := s.Groups.Heartbeat(req.Group, req.MemberId)
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	res := &api.HeartbeatResponse{Generation: a.Generation}
+	// Only send assignments when the member's are out of date.
+	if a.Generation != req.Generation {
+		res.Assignments = apiTopicPartitions(a.Partitions)
+	}
+
+	return res, nil
+}
+
+func (s *grpcServer) LeaveGroup(ctx context.Context, req *api.LeaveGroupRequest) (*api.LeaveGroupResponse, error) {
+	if s.Groups == nil {
+		return nil, errGroupsDisabled
+	}
+
+	if err := s.Groups.Leave(req.Group, req.MemberId); err != nil {
+		return nil, groupError(err)
+	}
+
+	return &api.LeaveGroupResponse{}, nil
+}
+
+// checkGroupRequest validates the group and makes sure
+// the partition it refers to exists.
+func (s *grpcServer) checkGroupRequest(group, topic string, partition uint32) error {
+	if s.Offsets == nil {
+		return errOffsetsDisabled
+	}
+	if group == "" || strings.ContainsRune(group, 0) {
+		return status.Errorf(codes.InvalidArgument, "invalid group: %q", group)
+	}
+
+	_, release, err := s.commitLog(topic, partition)
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+// commitLog returns the log a request is targeting. An empty
+// topic is the default CommitLog, which only has partition 0.
+// Topics are acquired so they can't be deleted while the request
+// uses the log, the returned func releases it once it's done.
+func (s *grpcServer) commitLog(name string, partition uint32) (CommitLog, func(), error) {
+	if name == "" {
+		if s.CommitLog == nil {
+			return nil, nil, status.Error(codes.InvalidArgument, "topic is required")
+		}
+		if partition != 0 {
+			return nil, nil, status.Errorf(codes.NotFound, "partition not found: %d", partition)
+		}
+		return s.CommitLog, func() {}, nil
+	}
+
+	if s.Topics == nil {
+		return nil, nil, errTopicsDisabled
+	}
+
+	t, err := s.Topics.Acquire(name)
+	if err != nil {
+		return nil, nil, topicError(err)
+	}
+
+	l, err := t.Partition(partition)
+	if err != nil {
+		t.Release()
+		return nil, nil, topicError(err)
+	}
+
+	return l, t.Release, nil
+}
+
+// partitionFor returns the partition a produce request should be
+// appended to, either the one it asked for or one picked by the topic.
+func (s *grpcServer) partitionFor(req *api.ProduceRequest) (uint32, error) {
+	if req.Partition != nil || req.Topic == "" || s.Topics == nil {
+		return req.GetPartition(), nil
+	}
+
+	t, err := s.Topics.Get(req.Topic)
+	if err != nil {
+		return 0, topicError(err)
+	}
+
+	return t.PartitionFor(req.Record.GetKey()), nil
+}
+
+// newProducerID returns a random non zero producer ID. IDs are random
+// rather than allocated so they stay unique across restarts without
+// the server persisting anything.
+func newProducerID() (uint64, error) {
+	b := make([]byte, 8)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, err
+		}
+		if id := binary.BigEndian.Uint64(b); id != 0 {
+			return id, nil
+		}
+	}
+}
+
+// produceError maps the log's idempotent producer errors to their
+// gRPC status so producers can tell them apart from retryable errors.
+func produceError(err error) error {
+	switch {
+	case errors.Is(err, log.ErrOutOfOrderSequence):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, log.ErrDuplicateSequence):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, log.ErrInvalidRecord):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
+// readError maps offsets outside of a log to OutOfRange, so clients
+// can tell them apart from the server failing.
+func readError(err error) error {
+	if errors.Is(err, log.ErrOffsetOutOfRange) {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	return err
+}
+
 func NewGRPCServer(config *Config) (*grpc.Server, error) {
-	gsrv := grpc.NewServer()
+	gsrv := grpc.NewServer(
+		grpc.MaxRecvMsgSize(MaxMessageBytes),
+		grpc.MaxSendMsgSize(MaxMessageBytes),
+	)
 	srv, err := newgrpcServer(config)
 	if err != nil {
 		return nil, err
@@ -60,4 +552,38 @@ func NewGRPCServer(config *Config) (*grpc.Server, error) {
 type CommitLog interface {
 	Append(*api.Record) (uint64, error)
 	Read(uint64) (*api.Record, error)
+	ReadCommitted(uint64) (*api.Record, error)
+	ReadRange(from uint64, maxRecords int, maxBytes uint64) ([]*api.Record, uint64, error)
+	ReadRaw(from uint64, maxRecords int, maxBytes uint64) (*log.RawRecords, uint64, error)
+	ReadCommittedRange(from uint64, maxRecords int, maxBytes uint64) ([]*api.Record, uint64, error)
+	EndTransaction(producerID uint64, commit bool) error
+	DeleteRecordsBefore(offset uint64) error
+	LowestOffset() (uint64, error)
+	NextOffset() (uint64, error)
+	LastStableOffset() uint64
+}
+
+type TransactionCoordinator interface {
+	Begin(producerID uint64) error
+	Add(producerID uint64, key string) (func(), error)
+	End(producerID uint64, commit bool) error
+}
+
+type OffsetStore interface {
+	Commit(group, topic string, partition uint32, offset uint64) error
+	Fetch(group, topic string, partition uint32) (uint64, error)
+}
+
+type GroupCoordinator interface {
+	Join(group, memberID string, topics []string, strategy group.Strategy) (*group.Assignment, error)
+	Heartbeat(group, memberID string) (*group.Assignment, error)
+	Leave(group, memberID string) error
+}
+
+type TopicRegistry interface {
+	Create(name string, c topic.Config) (*topic.Topic, error)
+	Get(name string) (*topic.Topic, error)
+	Acquire(name string) (*topic.Topic, error)
+	List() []*topic.Topic
+	Delete(name string) error
 }
@@ -0,0 +1,573 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/group"
+	"github.com/masonictemple4/proglog/internal/log"
+	"github.com/masonictemple4/proglog/internal/topic"
+	"github.com/masonictemple4/proglog/internal/txn"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, client api.LogClient, config *Config){
+		"produce/consume a message to/from the log succeeds": testProduceConsume,
+		"produce/consume to/from a topic succeeds":           testProduceConsumeTopic,
+		"create, list and delete topics":                     testTopicAdmin,
+		"produce/consume to/from partitions":                 testPartitions,
+		"commit and fetch consumer group offsets":            testCommitFetchOffset,
+		"join, heartbeat and leave a consumer group":         testGroupMembership,
+		"idempotent producer retries are deduplicated":       testIdempotentProduce,
+		"transactions span topics and partitions":            testTransactions,
+		"records can't set their own producer fields":        testRecordProducerFields,
+		"consume a batch of records":                         testConsumeBatch,
+		"delete records before an offset":                    testDeleteRecords,
+		"list a partition's offsets":                         testListOffsets,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client, config, teardown := setupTest(t)
+			defer teardown()
+			fn(t, client, config)
+		})
+	}
+}
+
+func setupTest(t *testing.T) (api.LogClient, *Config, func()) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+
+	require.NoError(t, os.Mkdir(dir+"/default", 0755))
+	clog, err := log.NewLog(dir+"/default", log.Config{})
+	require.NoError(t, err)
+
+	topics, err := topic.NewRegistry(dir+"/topics", topic.Config{})
+	require.NoError(t, err)
+
+	require.NoError(t, os.Mkdir(dir+"/offsets", 0755))
+	offsets, err := log.NewOffsets(dir+"/offsets", log.Config{})
+	require.NoError(t, err)
+
+	require.NoError(t, os.Mkdir(dir+"/transactions", 0755))
+	txns, err := txn.NewCoordinator(dir+"/transactions", txn.Config{
+		Partition: TransactionPartitions(clog, topics),
+	})
+	require.NoError(t, err)
+
+	config := &Config{
+		CommitLog:    clog,
+		Topics:       topics,
+		Offsets:      offsets,
+		Groups:       group.NewCoordinator(group.Config{Partitions: topics.Partitions}),
+		Transactions: txns,
+	}
+	server, err := NewGRPCServer(config)
+	require.NoError(t, err)
+
+	go func() {
+		server.Serve(l)
+	}()
+
+	return api.NewLogClient(cc), config, func() {
+		server.Stop()
+		cc.Close()
+		l.Close()
+		clog.Remove()
+		topics.Close()
+		offsets.Close()
+		txns.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testProduceConsume(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	want := &api.Record{
+		Value:     []byte("hello world"),
+		Key:       []byte("user-1"),
+		Timestamp: 1700000000000,
+		Headers:   map[string]string{"content-type": "text/plain"},
+	}
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, want.Value, consume.Record.Value)
+	require.Equal(t, produce.Offset, consume.Record.Offset)
+	require.Equal(t, want.Key, consume.Record.Key)
+	require.Equal(t, want.Timestamp, consume.Record.Timestamp)
+	require.Equal(t, want.Headers, consume.Record.Headers)
+	require.NotZero(t, consume.Record.AppendTimestamp)
+}
+
+func testProduceConsumeTopic(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+		Topic:  "events",
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "events"})
+	require.NoError(t, err)
+
+	want := &api.Record{Value: []byte("hello world")}
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: want, Topic: "events"})
+	require.NoError(t, err)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset, Topic: "events"})
+	require.NoError(t, err)
+	require.Equal(t, want.Value, consume.Record.Value)
+
+	// topics don't share offsets with the default log.
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.Error(t, err)
+}
+
+func testTopicAdmin(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	created, err := client.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{MaxStoreBytes: 4096, MaxAgeMs: 60000},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4096), created.Topic.Config.MaxStoreBytes)
+	require.Equal(t, uint64(60000), created.Topic.Config.MaxAgeMs)
+
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "events"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "../events"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := client.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Topics, 1)
+	require.Equal(t, "events", list.Topics[0].Name)
+	require.Equal(t, uint64(60000), list.Topics[0].Config.MaxAgeMs)
+
+	// topics using the default partition count report it.
+	config.Topics.(*topic.Registry).Config.Partitions = 3
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "billing"})
+	require.NoError(t, err)
+	list, err = client.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Topics, 2)
+	require.Equal(t, "billing", list.Topics[0].Name)
+	require.Equal(t, uint32(3), list.Topics[0].Config.Partitions)
+	require.Equal(t, uint32(1), list.Topics[1].Config.Partitions)
+	_, err = client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "billing"})
+	require.NoError(t, err)
+
+	_, err = client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "events"})
+	require.NoError(t, err)
+
+	_, err = client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "events"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err = client.ListTopics(ctx, &api.ListTopicsRequest{})
+	require.NoError(t, err)
+	require.Empty(t, list.Topics)
+}
+
+func testPartitions(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{Partitions: 4},
+	})
+	require.NoError(t, err)
+
+	// explicit partition.
+	partition := uint32(2)
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record:    &api.Record{Value: []byte("hello world")},
+		Topic:     "events",
+		Partition: &partition,
+	})
+	require.NoError(t, err)
+	require.Equal(t, partition, produce.Partition)
+	require.Equal(t, uint64(0), produce.Offset)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Topic: "events", Partition: partition})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+
+	// keyed records stick to one partition.
+	want := &api.Record{Value: []byte("hello world"), Key: []byte("user-1")}
+	first, err := client.Produce(ctx, &api.ProduceRequest{Record: want, Topic: "events"})
+	require.NoError(t, err)
+	second, err := client.Produce(ctx, &api.ProduceRequest{Record: want, Topic: "events"})
+	require.NoError(t, err)
+	require.Equal(t, first.Partition, second.Partition)
+
+	consume, err = client.Consume(ctx, &api.ConsumeRequest{Topic: "events", Partition: second.Partition, Offset: second.Offset})
+	require.NoError(t, err)
+	require.Equal(t, want.Key, consume.Record.Key)
+
+	partition = 4
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: want, Topic: "events", Partition: &partition})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testCommitFetchOffset(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{Partitions: 2},
+	})
+	require.NoError(t, err)
+
+	_, err = client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "workers", Topic: "events", Partition: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "workers", Topic: "events", Partition: 1, Offset: 3})
+	require.NoError(t, err)
+
+	fetch, err := client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "workers", Topic: "events", Partition: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), fetch.Offset)
+
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "workers", Topic: "events", Partition: 2})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Topic: "events"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testGroupMembership(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{Partitions: 4},
+	})
+	require.NoError(t, err)
+
+	_, err = client.JoinGroup(ctx, &api.JoinGroupRequest{Group: "workers", Topics: []string{"missing"}})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	a, err := client.JoinGroup(ctx, &api.JoinGroupRequest{
+		Group:    "workers",
+		Topics:   []string{"events"},
+		Strategy: api.AssignmentStrategy_ASSIGNMENT_STRATEGY_ROUND_ROBIN,
+	})
+	require.NoError(t, err)
+	require.Len(t, a.Assignments, 4)
+
+	b, err := client.JoinGroup(ctx, &api.JoinGroupRequest{
+		Group:    "workers",
+		Topics:   []string{"events"},
+		Strategy: api.AssignmentStrategy_ASSIGNMENT_STRATEGY_ROUND_ROBIN,
+	})
+	require.NoError(t, err)
+	require.Len(t, b.Assignments, 2)
+
+	// a's assignment changed with the new generation.
+	hb, err := client.Heartbeat(ctx, &api.HeartbeatRequest{Group: "workers", MemberId: a.MemberId, Generation: a.Generation})
+	require.NoError(t, err)
+	require.Equal(t, b.Generation, hb.Generation)
+	require.Len(t, hb.Assignments, 2)
+
+	// and is omitted once it's up to date.
+	hb, err = client.Heartbeat(ctx, &api.HeartbeatRequest{Group: "workers", MemberId: a.MemberId, Generation: hb.Generation})
+	require.NoError(t, err)
+	require.Empty(t, hb.Assignments)
+
+	_, err = client.LeaveGroup(ctx, &api.LeaveGroupRequest{Group: "workers", MemberId: b.MemberId})
+	require.NoError(t, err)
+
+	_, err = client.Heartbeat(ctx, &api.HeartbeatRequest{Group: "workers", MemberId: b.MemberId})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testIdempotentProduce(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	init, err := client.InitProducerId(ctx, &api.InitProducerIdRequest{})
+	require.NoError(t, err)
+	require.NotZero(t, init.ProducerId)
+
+	req := &api.ProduceRequest{
+		Record:     &api.Record{Value: []byte("hello world")},
+		ProducerId: init.ProducerId,
+		Sequence:   0,
+	}
+	first, err := client.Produce(ctx, req)
+	require.NoError(t, err)
+
+	retry, err := client.Produce(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, first.Offset, retry.Offset)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: first.Offset})
+	require.NoError(t, err)
+	require.Equal(t, init.ProducerId, consume.Record.ProducerId)
+
+	req.Sequence = 5
+	_, err = client.Produce(ctx, req)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func testRecordProducerFields(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	for _, record := range []*api.Record{
+		{Value: []byte("hello world"), Transactional: true},
+		{Value: []byte("hello world"), ProducerId: 1},
+		{Value: []byte("hello world"), Sequence: 1},
+		{Control: api.ControlType_CONTROL_TYPE_ABORT},
+	} {
+		_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	// none of them opened a transaction holding back read committed reads.
+	res, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset:    res.Offset,
+		Isolation: api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+	require.Zero(t, consume.Record.ProducerId)
+}
+
+func testTransactions(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "events",
+		Config: &api.TopicConfig{Partitions: 2},
+	})
+	require.NoError(t, err)
+
+	init, err := client.InitProducerId(ctx, &api.InitProducerIdRequest{})
+	require.NoError(t, err)
+	pid := init.ProducerId
+
+	produce := func(topic string, partition uint32, seq uint64, value string) uint64 {
+		res, err := client.Produce(ctx, &api.ProduceRequest{
+			Record:        &api.Record{Value: []byte(value)},
+			Topic:         topic,
+			Partition:     &partition,
+			ProducerId:    pid,
+			Sequence:      seq,
+			Transactional: true,
+		})
+		require.NoError(t, err)
+		return res.Offset
+	}
+	consume := func(topic string, partition uint32, off uint64) (*api.Record, error) {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    off,
+			Isolation: api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED,
+		})
+		return res.GetRecord(), err
+	}
+
+	// producing in a transaction needs one to be started.
+	partition := uint32(0)
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("hello world")},
+		Topic:         "events",
+		Partition:     &partition,
+		ProducerId:    pid,
+		Transactional: true,
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// an aborted transaction is never visible.
+	_, err = client.BeginTransaction(ctx, &api.BeginTransactionRequest{ProducerId: pid})
+	require.NoError(t, err)
+	produce("events", 0, 0, "aborted")
+	produce("", 0, 0, "aborted")
+	_, err = client.EndTransaction(ctx, &api.EndTransactionRequest{ProducerId: pid, Commit: false})
+	require.NoError(t, err)
+
+	_, err = consume("events", 0, 0)
+	require.Error(t, err)
+	_, err = consume("", 0, 0)
+	require.Error(t, err)
+
+	// a committed one becomes visible in every partition at once.
+	_, err = client.BeginTransaction(ctx, &api.BeginTransactionRequest{ProducerId: pid})
+	require.NoError(t, err)
+	// sequences are tracked per partition.
+	first := produce("events", 0, 1, "committed")
+	second := produce("events", 1, 0, "committed")
+
+	_, err = consume("events", 0, first)
+	require.Error(t, err)
+
+	// read uncommitted consumers see it straight away.
+	res, err := client.Consume(ctx, &api.ConsumeRequest{Topic: "events", Partition: 1, Offset: second})
+	require.NoError(t, err)
+	require.True(t, res.Record.Transactional)
+
+	_, err = client.EndTransaction(ctx, &api.EndTransactionRequest{ProducerId: pid, Commit: true})
+	require.NoError(t, err)
+
+	record, err := consume("events", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, first, record.Offset)
+	require.Equal(t, []byte("committed"), record.Value)
+
+	record, err = consume("events", 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, second, record.Offset)
+
+	// deleting a topic doesn't keep transactions that
+	// appended to it from ending.
+	_, err = client.BeginTransaction(ctx, &api.BeginTransactionRequest{ProducerId: pid})
+	require.NoError(t, err)
+	produce("events", 0, 2, "deleted")
+	produce("", 0, 1, "committed")
+	_, err = client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "events"})
+	require.NoError(t, err)
+	_, err = client.EndTransaction(ctx, &api.EndTransactionRequest{ProducerId: pid, Commit: true})
+	require.NoError(t, err)
+
+	record, err = consume("", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("committed"), record.Value)
+}
+
+func testConsumeBatch(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+		require.NoError(t, err)
+	}
+
+	var offsets []uint64
+	for next := uint64(0); ; {
+		res, err := client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{Offset: next, MaxRecords: 4})
+		require.NoError(t, err)
+		if len(res.Records) == 0 {
+			require.Equal(t, next, res.NextOffset)
+			break
+		}
+		require.LessOrEqual(t, len(res.Records), 4)
+		for _, record := range res.Records {
+			require.Equal(t, []byte("hello world"), record.Value)
+			offsets = append(offsets, record.Offset)
+		}
+		next = res.NextOffset
+	}
+	require.Len(t, offsets, 10)
+	require.Equal(t, uint64(9), offsets[9])
+
+	_, err := client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{Offset: 11})
+	require.Error(t, err)
+
+	// batches always hold a record, so records are
+	// capped to fit a response.
+	_, err = client.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:   "large",
+		Config: &api.TopicConfig{MaxStoreBytes: 2 * MaxMessageBytes},
+	})
+	require.NoError(t, err)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{Topic: "large", Record: &api.Record{Value: make([]byte, maxBatchBytes)}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	value := make([]byte, maxBatchBytes-16)
+	_, err = client.Produce(ctx, &api.ProduceRequest{Topic: "large", Record: &api.Record{Value: value}})
+	require.NoError(t, err)
+
+	res, err := client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{Topic: "large", MaxBytes: 1})
+	require.NoError(t, err)
+	require.Len(t, res.Records, 1)
+	require.Equal(t, value, res.Records[0].Value)
+}
+
+func testDeleteRecords(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+		require.NoError(t, err)
+	}
+
+	res, err := client.DeleteRecords(ctx, &api.DeleteRecordsRequest{Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.LowOffset)
+
+	// reading deleted records or past the end is out of range.
+	for _, isolation := range []api.IsolationLevel{
+		api.IsolationLevel_ISOLATION_LEVEL_READ_UNCOMMITTED,
+		api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED,
+	} {
+		_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1, Isolation: isolation})
+		require.Equal(t, codes.OutOfRange, status.Code(err))
+		_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 3, Isolation: isolation})
+		require.Equal(t, codes.OutOfRange, status.Code(err))
+		_, err = client.ConsumeBatch(ctx, &api.ConsumeBatchRequest{Offset: 1, Isolation: isolation})
+		require.Equal(t, codes.OutOfRange, status.Code(err))
+	}
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), consume.Record.Offset)
+
+	_, err = client.DeleteRecords(ctx, &api.DeleteRecordsRequest{Offset: 4})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	_, err = client.DeleteRecords(ctx, &api.DeleteRecordsRequest{Topic: "events"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testListOffsets(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	res, err := client.ListOffsets(ctx, &api.ListOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.LowOffset)
+	require.Equal(t, uint64(0), res.HighOffset)
+
+	for i := 0; i < 3; i++ {
+		_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+		require.NoError(t, err)
+	}
+	_, err = client.DeleteRecords(ctx, &api.DeleteRecordsRequest{Offset: 1})
+	require.NoError(t, err)
+
+	res, err = client.ListOffsets(ctx, &api.ListOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.LowOffset)
+	require.Equal(t, uint64(3), res.HighOffset)
+	require.Equal(t, uint64(3), res.LastStableOffset)
+
+	_, err = client.ListOffsets(ctx, &api.ListOffsetsRequest{Topic: "events"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
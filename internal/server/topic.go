@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/topic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errTopicsDisabled = status.Error(codes.Unimplemented, "topics are not enabled on this server")
+
+// topicError maps registry errors to their gRPC status.
+func topicError(err error) error {
+	switch {
+	case errors.Is(err, topic.ErrTopicNotFound), errors.Is(err, topic.ErrPartitionNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, topic.ErrTopicExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, topic.ErrInvalidTopicName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
+// topicConfig converts the api's topic config overrides to a topic.Config.
+func topicConfig(c *api.TopicConfig) topic.Config {
+	var tc topic.Config
+	tc.Partitions = c.GetPartitions()
+	tc.Log.Segment.MaxStoreBytes = c.GetMaxStoreBytes()
+	tc.Log.Segment.MaxIndexBytes = c.GetMaxIndexBytes()
+	tc.Log.Segment.InitialOffset = c.GetInitialOffset()
+	tc.Log.Segment.MaxAge = time.Duration(c.GetMaxAgeMs()) * time.Millisecond
+	return tc
+}
+
+// apiTopic converts the topic to the api's Topic. Its config holds the
+// topic's overrides, except for the partitions, which are always counted
+// so clients can partition records the way the server does.
+func apiTopic(t *topic.Topic) *api.Topic {
+	return &api.Topic{
+		Name: t.Name,
+		Config: &api.TopicConfig{
+			MaxStoreBytes: t.Config.Log.Segment.MaxStoreBytes,
+			MaxIndexBytes: t.Config.Log.Segment.MaxIndexBytes,
+			InitialOffset: t.Config.Log.Segment.InitialOffset,
+			Partitions:    uint32(len(t.Partitions)),
+			MaxAgeMs:      uint64(t.Config.Log.Segment.MaxAge.Milliseconds()),
+		},
+	}
+}
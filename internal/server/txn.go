@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/masonictemple4/proglog/internal/txn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errTransactionsDisabled = status.Error(codes.Unimplemented, "transactions are not enabled on this server")
+	errProducerIDRequired   = status.Error(codes.InvalidArgument, "transactions require a producer ID")
+)
+
+// transactionError maps coordinator errors to their gRPC status.
+func transactionError(err error) error {
+	switch {
+	case errors.Is(err, txn.ErrTransactionInProgress), errors.Is(err, txn.ErrNoTransaction),
+		errors.Is(err, txn.ErrTransactionEnding):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
+
+// partitionKey identifies a partition in the transaction coordinator,
+// the default log's topic is empty.
+func partitionKey(topic string, partition uint32) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
+
+// TransactionPartitions returns the txn.Config Partition func for a
+// server with the given commit log and topics, which looks partitions
+// up by the keys the server adds them to transactions with.
+func TransactionPartitions(clog CommitLog, topics TopicRegistry) func(key string) (txn.Partition, func(), error) {
+	s := &grpcServer{Config: &Config{CommitLog: clog, Topics: topics}}
+
+	return func(key string) (txn.Partition, func(), error) {
+		i := strings.LastIndexByte(key, '/')
+		if i < 0 {
+			return nil, nil, fmt.Errorf("invalid partition key: %q", key)
+		}
+		partition, err := strconv.ParseUint(key[i+1:], 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid partition key: %q", key)
+		}
+
+		p, release, err := s.commitLog(key[:i], uint32(partition))
+		if status.Code(err) == codes.NotFound {
+			return nil, nil, txn.ErrPartitionNotFound
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, release, nil
+	}
+}
@@ -0,0 +1,211 @@
+package topic
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+)
+
+// Registry manages a set of named topics, each stored
+// in a subdirectory of Dir.
+type Registry struct {
+	mu sync.RWMutex
+
+	Dir string
+	// Config is the default config for every topic,
+	// topics may override parts of it when created.
+	Config Config
+
+	topics map[string]*Topic
+	// Names of topics being deleted, which can't be reused
+	// until their data is removed.
+	deleting map[string]struct{}
+}
+
+// NewRegistry returns a registry rooted at dir, opening
+// every topic that already exists under it.
+func NewRegistry(dir string, c Config) (*Registry, error) {
+	r := &Registry{
+		Dir:      dir,
+		Config:   c,
+		topics:   make(map[string]*Topic),
+		deleting: make(map[string]struct{}),
+	}
+	return r, r.setup()
+}
+
+func (r *Registry) setup() error {
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		dir := path.Join(r.Dir, e.Name())
+		m, err := readMeta(dir)
+		// Directories without metadata weren't created by us.
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		t, err := openTopic(dir, m.Name, m.Config, r.Config)
+		if err != nil {
+			return err
+		}
+		r.topics[t.Name] = t
+	}
+
+	return nil
+}
+
+// Create makes a new topic with the given config overrides.
+func (r *Registry) Create(name string, c Config) (*Topic, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.topics[name]; ok {
+		return nil, ErrTopicExists
+	}
+	if _, ok := r.deleting[name]; ok {
+		return nil, ErrTopicExists
+	}
+
+	// A directory without metadata is what's left of a topic whose
+	// delete was interrupted, it's cleared so its data isn't reused.
+	dir := path.Join(r.Dir, name)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// The metadata's written before the partitions are created so a
+	// crash in between leaves a topic that's opened on startup, rather
+	// than a directory that isn't registered.
+	if err := writeMeta(dir, meta{Name: name, Config: c}); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	t, err := openTopic(dir, name, c, r.Config)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	r.topics[name] = t
+	return t, nil
+}
+
+// Get returns the topic with the given name.
+func (r *Registry) Get(name string) (*Topic, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.topics[name]
+	if !ok {
+		return nil, ErrTopicNotFound
+	}
+	return t, nil
+}
+
+// Acquire returns the topic with the given name, which can't be deleted
+// until it's released. Callers using the topic's partitions acquire it
+// so Delete doesn't close them from under them, and mustn't acquire it
+// again before releasing it.
+func (r *Registry) Acquire(name string) (*Topic, error) {
+	t, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.RLock()
+	if t.deleted {
+		t.mu.RUnlock()
+		return nil, ErrTopicNotFound
+	}
+	return t, nil
+}
+
+// Partitions returns the number of partitions in the named topic.
+func (r *Registry) Partitions(name string) (uint32, error) {
+	t, err := r.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(len(t.Partitions)), nil
+}
+
+// List returns every topic sorted by name.
+func (r *Registry) List() []*Topic {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	topics := make([]*Topic, 0, len(r.topics))
+	for _, t := range r.topics {
+		topics = append(topics, t)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+	return topics
+}
+
+// Delete closes the topic's partitions and removes its data. The topic
+// can't be got once Delete's called, but its partitions are only closed
+// once every user that acquired it has released it.
+func (r *Registry) Delete(name string) error {
+	r.mu.Lock()
+	t, ok := r.topics[name]
+	if !ok {
+		r.mu.Unlock()
+		return ErrTopicNotFound
+	}
+	delete(r.topics, name)
+	r.deleting[name] = struct{}{}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.deleting, name)
+		r.mu.Unlock()
+	}()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deleted = true
+	return t.Remove()
+}
+
+// Close closes every topic.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.topics {
+		if err := t.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package topic
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, r *Registry){
+		"create and get a topic":           testCreateGet,
+		"create existing topic errors":     testCreateExisting,
+		"invalid topic names":              testInvalidName,
+		"list and delete topics":           testListDelete,
+		"topics are reopened with config":  testReopen,
+		"delete waits for acquired topics": testDeleteAcquired,
+		"interrupted creates and deletes":  testInterrupted,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "registry-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Log.Segment.MaxStoreBytes = 32
+			r, err := NewRegistry(dir, c)
+			require.NoError(t, err)
+			fn(t, r)
+		})
+	}
+}
+
+func testCreateGet(t *testing.T, r *Registry) {
+	created, err := r.Create("events", Config{})
+	require.NoError(t, err)
+
+	got, err := r.Get("events")
+	require.NoError(t, err)
+	require.Equal(t, created, got)
+
+	off, err := got.Partitions[0].Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	n, err := r.Partitions("events")
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), n)
+
+	_, err = r.Get("missing")
+	require.Equal(t, ErrTopicNotFound, err)
+}
+
+func testCreateExisting(t *testing.T, r *Registry) {
+	_, err := r.Create("events", Config{})
+	require.NoError(t, err)
+
+	_, err = r.Create("events", Config{})
+	require.Equal(t, ErrTopicExists, err)
+}
+
+func testInvalidName(t *testing.T, r *Registry) {
+	for _, name := range []string{"", ".", "..", "a/b", "with space", "__consumer_offsets"} {
+		_, err := r.Create(name, Config{})
+		require.ErrorIs(t, err, ErrInvalidTopicName, name)
+	}
+}
+
+func testListDelete(t *testing.T, r *Registry) {
+	for _, name := range []string{"b", "a", "c"} {
+		_, err := r.Create(name, Config{})
+		require.NoError(t, err)
+	}
+
+	var names []string
+	for _, topic := range r.List() {
+		names = append(names, topic.Name)
+	}
+	require.Equal(t, []string{"a", "b", "c"}, names)
+
+	require.NoError(t, r.Delete("b"))
+	require.Len(t, r.List(), 2)
+	require.Equal(t, ErrTopicNotFound, r.Delete("b"))
+
+	_, err := os.Stat(r.Dir + "/b")
+	require.True(t, os.IsNotExist(err))
+}
+
+func testReopen(t *testing.T, r *Registry) {
+	c := Config{Partitions: 2}
+	c.Log.Segment.InitialOffset = 10
+	topic, err := r.Create("events", c)
+	require.NoError(t, err)
+
+	_, err = topic.Partitions[1].Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	n, err := NewRegistry(r.Dir, r.Config)
+	require.NoError(t, err)
+	defer n.Close()
+
+	topic, err = n.Get("events")
+	require.NoError(t, err)
+	require.Equal(t, c, topic.Config)
+	require.Len(t, topic.Partitions, 2)
+	require.Equal(t, uint64(32), topic.Partitions[1].Config.Segment.MaxStoreBytes)
+
+	off, err := topic.Partitions[1].HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+}
+
+func testDeleteAcquired(t *testing.T, r *Registry) {
+	_, err := r.Create("events", Config{})
+	require.NoError(t, err)
+
+	topic, err := r.Acquire("events")
+	require.NoError(t, err)
+
+	deleted := make(chan error)
+	go func() {
+		deleted <- r.Delete("events")
+	}()
+
+	// the topic's gone right away, but its partitions stay open
+	// and its name can't be reused until it's released.
+	require.Eventually(t, func() bool {
+		_, err := r.Get("events")
+		return err == ErrTopicNotFound
+	}, time.Second, time.Millisecond)
+	_, err = r.Create("events", Config{})
+	require.Equal(t, ErrTopicExists, err)
+
+	_, err = topic.Partitions[0].Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	select {
+	case <-deleted:
+		t.Fatal("topic deleted while it was acquired")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	topic.Release()
+	require.NoError(t, <-deleted)
+
+	_, err = r.Acquire("events")
+	require.Equal(t, ErrTopicNotFound, err)
+	_, err = r.Create("events", Config{})
+	require.NoError(t, err)
+}
+
+func testInterrupted(t *testing.T, r *Registry) {
+	// a create interrupted after writing the topic's metadata
+	// leaves a topic that's opened on startup.
+	require.NoError(t, os.Mkdir(r.Dir+"/created", 0755))
+	require.NoError(t, writeMeta(r.Dir+"/created", meta{Name: "created", Config: Config{Partitions: 2}}))
+
+	// a delete interrupted after removing the topic's metadata
+	// leaves data that isn't reopened or reused.
+	topic, err := r.Create("deleted", Config{})
+	require.NoError(t, err)
+	_, err = topic.Partitions[0].Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.NoError(t, os.Remove(r.Dir+"/deleted/"+metaFile))
+
+	n, err := NewRegistry(r.Dir, r.Config)
+	require.NoError(t, err)
+	defer n.Close()
+
+	created, err := n.Get("created")
+	require.NoError(t, err)
+	require.Len(t, created.Partitions, 2)
+
+	_, err = n.Get("deleted")
+	require.Equal(t, ErrTopicNotFound, err)
+
+	topic, err = n.Create("deleted", Config{})
+	require.NoError(t, err)
+	off, err := topic.Partitions[0].NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+}
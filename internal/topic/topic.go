@@ -0,0 +1,210 @@
+package topic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/masonictemple4/proglog/internal/log"
+)
+
+const (
+	// Name of the file in a topic's directory that persists
+	// its config overrides.
+	metaFile = "topic.json"
+	// Longest topic name we accept, keeps directory names sane.
+	maxNameLen = 249
+	// Topic names with this prefix are reserved for internal logs
+	// (e.g. committed offsets) kept alongside the topics.
+	internalPrefix = "__"
+)
+
+var (
+	ErrTopicNotFound     = fmt.Errorf("topic not found")
+	ErrTopicExists       = fmt.Errorf("topic already exists")
+	ErrInvalidTopicName  = fmt.Errorf("invalid topic name")
+	ErrPartitionNotFound = fmt.Errorf("partition not found")
+
+	validName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// Config describes how a topic is laid out.
+type Config struct {
+	// Number of partitions in the topic. Defaults to 1.
+	Partitions uint32
+	// Log is the config for each partition's log.
+	Log log.Config
+}
+
+// Topic is a named set of partitions living in its own
+// subdirectory of the registry's data root. Each partition
+// is a separate log so appends to different partitions
+// don't contend with each other.
+type Topic struct {
+	Name string
+	// Config holds the overrides the topic was created with.
+	// Zero values inherit the registry's defaults.
+	Config Config
+
+	Dir        string
+	Partitions []*log.Log
+
+	// Counter for spreading keyless records between partitions.
+	next atomic.Uint32
+
+	// Held shared by users that acquired the topic and
+	// exclusively by Delete, which waits for them to release it.
+	mu      sync.RWMutex
+	deleted bool
+}
+
+// meta is the on disk representation of a topic.
+type meta struct {
+	Name   string `json:"name"`
+	Config Config `json:"config"`
+}
+
+// openTopic opens (or creates) the topic stored in dir, the effective
+// config is the defaults with the topic's overrides on top.
+func openTopic(dir, name string, overrides, defaults Config) (*Topic, error) {
+	c := mergeConfig(defaults, overrides)
+
+	t := &Topic{
+		Name:   name,
+		Config: overrides,
+		Dir:    dir,
+	}
+
+	for p := uint32(0); p < c.Partitions; p++ {
+		pdir := path.Join(dir, strconv.FormatUint(uint64(p), 10))
+		if err := os.MkdirAll(pdir, 0755); err != nil {
+			return nil, err
+		}
+
+		l, err := log.NewLog(pdir, c.Log)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.Partitions = append(t.Partitions, l)
+	}
+
+	return t, nil
+}
+
+// Partition returns the log for partition p.
+func (t *Topic) Partition(p uint32) (*log.Log, error) {
+	if p >= uint32(len(t.Partitions)) {
+		return nil, fmt.Errorf("%w: %s/%d", ErrPartitionNotFound, t.Name, p)
+	}
+	return t.Partitions[p], nil
+}
+
+// PartitionFor picks the partition a record with the given key
+// belongs to. Records with the same key always land on the same
+// partition, records without a key are spread round-robin.
+func (t *Topic) PartitionFor(key []byte) uint32 {
+	n := uint32(len(t.Partitions))
+	if len(key) == 0 {
+		return (t.next.Add(1) - 1) % n
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32() % n
+}
+
+// Close closes every partition's log.
+func (t *Topic) Close() error {
+	for _, l := range t.Partitions {
+		if err := l.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release ends a use of the topic started by Registry.Acquire.
+func (t *Topic) Release() {
+	t.mu.RUnlock()
+}
+
+// Remove closes the topic and removes all of its data. The metadata's
+// removed first so a topic that's partially removed isn't reopened.
+func (t *Topic) Remove() error {
+	if err := os.Remove(path.Join(t.Dir, metaFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(t.Dir)
+}
+
+// writeMeta persists a topic's name and overrides to dir.
+func writeMeta(dir string, m meta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return log.WriteFileAtomic(path.Join(dir, metaFile), b, 0644)
+}
+
+// readMeta loads a topic's metadata from dir.
+func readMeta(dir string) (meta, error) {
+	var m meta
+	b, err := os.ReadFile(path.Join(dir, metaFile))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// validateName makes sure the name is safe to use as a directory.
+func validateName(name string) error {
+	if name == "." || name == ".." || len(name) > maxNameLen || !validName.MatchString(name) ||
+		strings.HasPrefix(name, internalPrefix) {
+		return fmt.Errorf("%w: %q", ErrInvalidTopicName, name)
+	}
+	return nil
+}
+
+// mergeConfig returns the defaults with every non zero override applied.
+func mergeConfig(defaults, overrides Config) Config {
+	c := defaults
+	if overrides.Partitions != 0 {
+		c.Partitions = overrides.Partitions
+	}
+	if c.Partitions == 0 {
+		c.Partitions = 1
+	}
+	if overrides.Log.Segment.MaxStoreBytes != 0 {
+		c.Log.Segment.MaxStoreBytes = overrides.Log.Segment.MaxStoreBytes
+	}
+	if overrides.Log.Segment.MaxIndexBytes != 0 {
+		c.Log.Segment.MaxIndexBytes = overrides.Log.Segment.MaxIndexBytes
+	}
+	if overrides.Log.Segment.InitialOffset != 0 {
+		c.Log.Segment.InitialOffset = overrides.Log.Segment.InitialOffset
+	}
+	if overrides.Log.Segment.IndexIntervalBytes != 0 {
+		c.Log.Segment.IndexIntervalBytes = overrides.Log.Segment.IndexIntervalBytes
+	}
+	if overrides.Log.Segment.WideIndex {
+		c.Log.Segment.WideIndex = true
+	}
+	if overrides.Log.Segment.MaxAge != 0 {
+		c.Log.Segment.MaxAge = overrides.Log.Segment.MaxAge
+	}
+	return c
+}
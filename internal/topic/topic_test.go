@@ -0,0 +1,38 @@
+package topic
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicPartitions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "topic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	topic, err := openTopic(dir, "events", Config{Partitions: 3}, Config{})
+	require.NoError(t, err)
+	defer topic.Close()
+	require.Len(t, topic.Partitions, 3)
+
+	_, err = topic.Partition(2)
+	require.NoError(t, err)
+	_, err = topic.Partition(3)
+	require.ErrorIs(t, err, ErrPartitionNotFound)
+
+	// keyed records always land on the same partition.
+	key := []byte("user-1")
+	p := topic.PartitionFor(key)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, p, topic.PartitionFor(key))
+	}
+
+	// keyless records are spread across every partition.
+	seen := map[uint32]bool{}
+	for i := 0; i < 3; i++ {
+		seen[topic.PartitionFor(nil)] = true
+	}
+	require.Len(t, seen, 3)
+}
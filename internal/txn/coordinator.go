@@ -0,0 +1,386 @@
+package txn
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/masonictemple4/proglog/api/v1"
+	"github.com/masonictemple4/proglog/internal/log"
+)
+
+var (
+	ErrTransactionInProgress = fmt.Errorf("producer already has a transaction in progress")
+	ErrNoTransaction         = fmt.Errorf("producer has no transaction in progress")
+	ErrTransactionEnding     = fmt.Errorf("producer's transaction is already being ended")
+	ErrPartitionNotFound     = fmt.Errorf("partition not found")
+)
+
+// Partition is a log records can be appended to within a transaction.
+type Partition interface {
+	EndTransaction(producerID uint64, commit bool) error
+}
+
+// Config configures how long transactions may stay open, how
+// partitions are found to write end markers to and the transaction
+// log's segments.
+type Config struct {
+	// Timeout is how long a transaction may stay open before it's
+	// aborted, so an abandoned transaction can't hold back read
+	// committed consumers forever. Defaults to one minute.
+	Timeout time.Duration
+	// Partition looks up a partition added to a transaction by its key,
+	// returning a func to release it once its end marker is written.
+	// Partitions that were deleted return ErrPartitionNotFound, they
+	// don't need a marker.
+	Partition func(key string) (Partition, func(), error)
+	// Log configures the transaction log.
+	Log log.Config
+}
+
+// Transaction states, as written to the transaction log.
+const (
+	stateOngoing  = "ongoing"
+	stateCommit   = "commit"
+	stateAbort    = "abort"
+	stateComplete = "complete"
+)
+
+// Coordinator tracks every producer's open transaction and the
+// partitions it has appended to, so ending it can commit or abort
+// the transaction in all of them.
+//
+// Every partition added to a transaction and the decision to commit or
+// abort it are appended to a transaction log before any end markers are
+// written. Transactions stay tracked until every partition has its
+// marker, and the log is replayed when the coordinator's created so it
+// can finish ending them. Transactions that weren't being ended are
+// aborted, so a restart aborts every transaction that was in progress.
+//
+// The transaction log is compacted by appending the states of the
+// transactions in progress again, so finished transactions don't stay
+// in the log.
+//
+// Each record's key is the big endian producer ID and its value the
+// transaction's state and partitions as JSON.
+type Coordinator struct {
+	mu sync.Mutex
+	// Signalled when a transaction has no appends in flight.
+	idle *sync.Cond
+
+	Config Config
+
+	log  *log.CompactedLog
+	txns map[uint64]*transaction
+	// Returns the time transactions start and expire by,
+	// tests replace it to expire them without waiting.
+	now func() time.Time
+}
+
+type transaction struct {
+	started time.Time
+	// Keys of the partitions added to the transaction that
+	// don't have its end marker yet.
+	partitions map[string]struct{}
+	// Appends to the transaction that haven't returned.
+	inflight int
+	// Set once the transaction is being ended, no more
+	// partitions or appends can be added to it.
+	ending bool
+	// Set once the decision whether to commit is logged.
+	decided bool
+	commit  bool
+}
+
+// entry is a transaction's state as written to the transaction log.
+type entry struct {
+	State      string   `json:"state"`
+	Partitions []string `json:"partitions,omitempty"`
+}
+
+// NewCoordinator opens the transaction log stored in dir, replays it
+// and finishes ending the transactions that were in progress.
+func NewCoordinator(dir string, c Config) (*Coordinator, error) {
+	if c.Timeout == 0 {
+		c.Timeout = time.Minute
+	}
+	l, err := log.NewCompactedLog(dir, c.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	co := &Coordinator{
+		Config: c,
+		log:    l,
+		txns:   make(map[uint64]*transaction),
+		now:    time.Now,
+	}
+	co.idle = sync.NewCond(&co.mu)
+
+	return co, co.setup()
+}
+
+func (c *Coordinator) setup() error {
+	err := c.log.Replay(func(record *api.Record) error {
+		if len(record.Key) != 8 {
+			return fmt.Errorf("invalid transaction at %d", record.Offset)
+		}
+
+		var e entry
+		if err := json.Unmarshal(record.Value, &e); err != nil {
+			return fmt.Errorf("invalid transaction at %d: %w", record.Offset, err)
+		}
+
+		id := binary.BigEndian.Uint64(record.Key)
+		if e.State == stateComplete {
+			delete(c.txns, id)
+			return nil
+		}
+
+		t := &transaction{partitions: make(map[string]struct{})}
+		for _, key := range e.Partitions {
+			t.partitions[key] = struct{}{}
+		}
+		t.decided = e.State != stateOngoing
+		t.ending = t.decided
+		t.commit = e.State == stateCommit
+		c.txns[id] = t
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, t := range c.txns {
+		if !t.decided {
+			t.ending = true
+			if err := c.decide(id, t, false); err != nil {
+				return err
+			}
+		}
+		// Partitions that fail to write their marker
+		// are retried as the coordinator is used.
+		c.finish(id, t)
+	}
+	return c.compact()
+}
+
+// Begin starts a transaction for the producer.
+func (c *Coordinator) Begin(producerID uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	if _, ok := c.txns[producerID]; ok {
+		return ErrTransactionInProgress
+	}
+
+	c.txns[producerID] = &transaction{
+		started:    c.now(),
+		partitions: make(map[string]struct{}),
+	}
+	return nil
+}
+
+// Add registers partition, identified by key, as part of the producer's
+// transaction. It must be called before appending to the partition, and
+// the returned func called once the append returns. The transaction
+// doesn't end until then, so the record can't land after its marker.
+func (c *Coordinator) Add(producerID uint64, key string) (func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	t, ok := c.txns[producerID]
+	if !ok || t.ending {
+		return nil, ErrNoTransaction
+	}
+
+	if _, ok := t.partitions[key]; !ok {
+		t.partitions[key] = struct{}{}
+		if err := c.write(producerID, stateOngoing, t); err != nil {
+			delete(t.partitions, key)
+			return nil, err
+		}
+	}
+
+	t.inflight++
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		t.inflight--
+		if t.inflight == 0 {
+			c.idle.Broadcast()
+		}
+	}, nil
+}
+
+// End commits or aborts the producer's transaction in every partition
+// it appended to. The decision is logged before any partition's marker
+// is written, if some fail the transaction stays in progress and ending
+// it again retries them. It can't be ended the other way once decided.
+func (c *Coordinator) End(producerID uint64, commit bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.txns[producerID]
+	if !ok {
+		return ErrNoTransaction
+	}
+
+	// Expiring may abort the transaction, or finish it
+	// when it's retrying a failed End.
+	c.expire()
+
+	if t.ending {
+		if !t.decided || t.commit != commit {
+			return ErrTransactionEnding
+		}
+		if _, ok := c.txns[producerID]; !ok {
+			return nil
+		}
+		return c.finish(producerID, t)
+	}
+
+	t.ending = true
+	for t.inflight > 0 {
+		c.idle.Wait()
+	}
+
+	if err := c.decide(producerID, t, commit); err != nil {
+		t.ending = false
+		return err
+	}
+	return c.finish(producerID, t)
+}
+
+// Close closes the transaction log.
+func (c *Coordinator) Close() error {
+	return c.log.Close()
+}
+
+// expire aborts transactions that have been open longer than the
+// timeout, retries writing the markers of transactions that failed
+// to end and compacts the log when it's due. Expiry happens lazily
+// as the coordinator is used. The caller must hold c.mu.
+func (c *Coordinator) expire() {
+	deadline := c.now().Add(-c.Config.Timeout)
+	for id, t := range c.txns {
+		if !t.decided {
+			if t.ending || t.inflight > 0 || !t.started.Before(deadline) {
+				continue
+			}
+			t.ending = true
+			if err := c.decide(id, t, false); err != nil {
+				t.ending = false
+				continue
+			}
+		}
+		c.finish(id, t)
+	}
+	// Compacting's retried the next time if it fails.
+	c.compact()
+}
+
+// compact appends the state of every transaction in progress again
+// once the log's due to be compacted. Transactions nothing was added
+// to yet aren't logged. The caller must hold c.mu or be setting the
+// coordinator up.
+func (c *Coordinator) compact() error {
+	return c.log.Compact(len(c.txns), func() error {
+		for id, t := range c.txns {
+			state := stateOngoing
+			switch {
+			case t.decided && t.commit:
+				state = stateCommit
+			case t.decided:
+				state = stateAbort
+			case len(t.partitions) == 0:
+				continue
+			}
+			if err := c.write(id, state, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// decide logs whether the transaction commits. The caller must hold c.mu.
+func (c *Coordinator) decide(producerID uint64, t *transaction, commit bool) error {
+	state := stateAbort
+	if commit {
+		state = stateCommit
+	}
+	if err := c.write(producerID, state, t); err != nil {
+		return err
+	}
+
+	t.decided, t.commit = true, commit
+	return nil
+}
+
+// finish writes the transaction's end marker to each of its partitions
+// that doesn't have it yet, then forgets the transaction. If a partition
+// fails the transaction is kept so it can be finished later.
+// The caller must hold c.mu.
+func (c *Coordinator) finish(producerID uint64, t *transaction) error {
+	for key := range t.partitions {
+		if err := c.mark(producerID, key, t.commit); err != nil {
+			return err
+		}
+		delete(t.partitions, key)
+	}
+
+	if err := c.write(producerID, stateComplete, t); err != nil {
+		return err
+	}
+	delete(c.txns, producerID)
+	return nil
+}
+
+// mark writes the producer's end marker to the partition.
+func (c *Coordinator) mark(producerID uint64, key string, commit bool) error {
+	p, release, err := c.Config.Partition(key)
+	if errors.Is(err, ErrPartitionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return p.EndTransaction(producerID, commit)
+}
+
+// write appends the transaction's state to the transaction log.
+func (c *Coordinator) write(producerID uint64, state string, t *transaction) error {
+	e := entry{State: state}
+	if state != stateComplete {
+		for key := range t.partitions {
+			e.Partitions = append(e.Partitions, key)
+		}
+		sort.Strings(e.Partitions)
+	}
+
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, producerID)
+
+	_, err = c.log.Append(&api.Record{Key: key, Value: value})
+	return err
+}
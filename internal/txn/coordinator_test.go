@@ -0,0 +1,266 @@
+package txn
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// partition records how transactions were ended in it.
+type partition struct {
+	ended map[uint64]bool
+	// Returned instead of ending transactions when set.
+	err error
+}
+
+func (p *partition) EndTransaction(producerID uint64, commit bool) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.ended[producerID] = commit
+	return nil
+}
+
+func newPartition() *partition {
+	return &partition{ended: make(map[uint64]bool)}
+}
+
+// newCoordinator opens a coordinator in dir that looks partitions up
+// in partitions, with its clock returned so tests can move it.
+func newCoordinator(t *testing.T, dir string, partitions map[string]*partition) (*Coordinator, *time.Time) {
+	t.Helper()
+
+	c, err := NewCoordinator(dir, Config{
+		Timeout: time.Second,
+		Partition: func(key string) (Partition, func(), error) {
+			p, ok := partitions[key]
+			if !ok {
+				return nil, nil, ErrPartitionNotFound
+			}
+			return p, func() {}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	clock := time.Now()
+	c.now = func() time.Time { return clock }
+	return c, &clock
+}
+
+// add adds the partition to the producer's transaction,
+// as if a record was appended to it.
+func add(t *testing.T, c *Coordinator, producerID uint64, key string) {
+	t.Helper()
+	done, err := c.Add(producerID, key)
+	require.NoError(t, err)
+	done()
+}
+
+func TestCoordinator(t *testing.T) {
+	dir, err := os.MkdirTemp("", "txn-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, b := newPartition(), newPartition()
+	c, _ := newCoordinator(t, dir, map[string]*partition{"events/0": a, "billing/0": b})
+	defer c.Close()
+
+	_, err = c.Add(1, "events/0")
+	require.Equal(t, ErrNoTransaction, err)
+	require.Equal(t, ErrNoTransaction, c.End(1, true))
+
+	require.NoError(t, c.Begin(1))
+	require.Equal(t, ErrTransactionInProgress, c.Begin(1))
+	add(t, c, 1, "events/0")
+	add(t, c, 1, "billing/0")
+
+	require.NoError(t, c.End(1, true))
+	require.Equal(t, map[uint64]bool{1: true}, a.ended)
+	require.Equal(t, map[uint64]bool{1: true}, b.ended)
+
+	// transactions can be started again once ended.
+	require.NoError(t, c.Begin(1))
+	add(t, c, 1, "events/0")
+	require.NoError(t, c.End(1, false))
+	require.Equal(t, map[uint64]bool{1: false}, a.ended)
+}
+
+func TestCoordinatorTimeout(t *testing.T) {
+	dir, err := os.MkdirTemp("", "txn-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := newPartition()
+	c, clock := newCoordinator(t, dir, map[string]*partition{"events/0": p})
+	defer c.Close()
+
+	require.NoError(t, c.Begin(1))
+	add(t, c, 1, "events/0")
+
+	*clock = clock.Add(2 * time.Second)
+	require.NoError(t, c.Begin(2))
+
+	// the expired transaction was aborted.
+	require.Equal(t, map[uint64]bool{1: false}, p.ended)
+	require.Equal(t, ErrNoTransaction, c.End(1, true))
+}
+
+func TestCoordinatorInflight(t *testing.T) {
+	dir, err := os.MkdirTemp("", "txn-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := newPartition()
+	c, clock := newCoordinator(t, dir, map[string]*partition{"events/0": p})
+	defer c.Close()
+
+	require.NoError(t, c.Begin(1))
+	done, err := c.Add(1, "events/0")
+	require.NoError(t, err)
+
+	// transactions with appends in flight don't expire.
+	*clock = clock.Add(2 * time.Second)
+	require.NoError(t, c.Begin(2))
+	require.Empty(t, p.ended)
+
+	ended := make(chan error)
+	go func() {
+		ended <- c.End(1, true)
+	}()
+
+	select {
+	case <-ended:
+		t.Fatal("transaction ended with an append in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// nothing more can be appended once it's ending.
+	_, err = c.Add(1, "events/0")
+	require.Equal(t, ErrNoTransaction, err)
+
+	done()
+	require.NoError(t, <-ended)
+	require.Equal(t, map[uint64]bool{1: true}, p.ended)
+}
+
+func TestCoordinatorFailedMarkers(t *testing.T) {
+	dir, err := os.MkdirTemp("", "txn-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, b := newPartition(), newPartition()
+	c, _ := newCoordinator(t, dir, map[string]*partition{"events/0": a, "billing/0": b})
+	defer c.Close()
+
+	require.NoError(t, c.Begin(1))
+	add(t, c, 1, "events/0")
+	add(t, c, 1, "billing/0")
+
+	b.err = fmt.Errorf("disk full")
+	require.Equal(t, b.err, c.End(1, true))
+
+	// the transaction is kept until every partition has its marker,
+	// and the decision can't change.
+	require.Equal(t, ErrTransactionInProgress, c.Begin(1))
+	require.Equal(t, ErrTransactionEnding, c.End(1, false))
+
+	b.err = nil
+	require.NoError(t, c.End(1, true))
+	require.Equal(t, map[uint64]bool{1: true}, a.ended)
+	require.Equal(t, map[uint64]bool{1: true}, b.ended)
+	require.NoError(t, c.Begin(1))
+}
+
+func TestCoordinatorRecovery(t *testing.T) {
+	dir, err := os.MkdirTemp("", "txn-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, b := newPartition(), newPartition()
+	partitions := map[string]*partition{"events/0": a, "billing/0": b}
+	c, _ := newCoordinator(t, dir, partitions)
+
+	// decided to commit, but a marker's missing.
+	require.NoError(t, c.Begin(1))
+	add(t, c, 1, "events/0")
+	add(t, c, 1, "billing/0")
+	b.err = fmt.Errorf("disk full")
+	require.Error(t, c.End(1, true))
+
+	// still in progress.
+	require.NoError(t, c.Begin(2))
+	add(t, c, 2, "events/0")
+	add(t, c, 2, "deleted/0")
+
+	require.NoError(t, c.Close())
+
+	b.err = nil
+	c, _ = newCoordinator(t, dir, partitions)
+
+	require.Equal(t, map[uint64]bool{1: true, 2: false}, a.ended)
+	require.Equal(t, map[uint64]bool{1: true}, b.ended)
+
+	// both transactions are over.
+	require.Equal(t, ErrNoTransaction, c.End(1, true))
+	require.Equal(t, ErrNoTransaction, c.End(2, true))
+	require.NoError(t, c.Close())
+
+	// and stay over.
+	a.ended, b.ended = make(map[uint64]bool), make(map[uint64]bool)
+	c, _ = newCoordinator(t, dir, partitions)
+	require.Empty(t, a.ended)
+	require.Empty(t, b.ended)
+	require.NoError(t, c.Close())
+}
+
+func TestCoordinatorCompaction(t *testing.T) {
+	dir, err := os.MkdirTemp("", "txn-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, b := newPartition(), newPartition()
+	partitions := map[string]*partition{"events/0": a, "billing/0": b}
+	c, _ := newCoordinator(t, dir, partitions)
+	c.log.CompactMin = 4
+
+	// decided to commit, but a marker's missing.
+	require.NoError(t, c.Begin(1))
+	add(t, c, 1, "events/0")
+	add(t, c, 1, "billing/0")
+	b.err = fmt.Errorf("disk full")
+	require.Error(t, c.End(1, true))
+
+	// still in progress.
+	require.NoError(t, c.Begin(2))
+	add(t, c, 2, "events/0")
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, c.Begin(3))
+		add(t, c, 3, "events/0")
+		require.NoError(t, c.End(3, i%2 == 0))
+	}
+
+	// only the transactions in progress and the records since
+	// they were last appended are kept, at most one call's worth
+	// past when compacting's due.
+	lowest, err := c.log.LowestOffset()
+	require.NoError(t, err)
+	next, err := c.log.NextOffset()
+	require.NoError(t, err)
+	require.LessOrEqual(t, next-lowest, uint64(c.log.CompactMin+len(c.txns)+3))
+	require.NoError(t, c.Close())
+
+	a.ended, b.ended = make(map[uint64]bool), make(map[uint64]bool)
+	b.err = nil
+	c, _ = newCoordinator(t, dir, partitions)
+	defer c.Close()
+
+	// the transactions in progress were recovered, and the ones
+	// that ended were not. Partitions that already had their
+	// marker weren't kept.
+	require.Equal(t, map[uint64]bool{2: false}, a.ended)
+	require.Equal(t, map[uint64]bool{1: true}, b.ended)
+}
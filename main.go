@@ -1,12 +1,120 @@
+// The proglog server. It serves the default log, topics, consumer
+// groups and transactions over gRPC, which the proglog CLI in
+// cmd/proglog talks to, along with the HTTP API.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
 
+	"github.com/masonictemple4/proglog/internal/group"
+	commitlog "github.com/masonictemple4/proglog/internal/log"
 	"github.com/masonictemple4/proglog/internal/server"
+	"github.com/masonictemple4/proglog/internal/topic"
+	"github.com/masonictemple4/proglog/internal/txn"
 )
 
 func main() {
-	srv := server.NewHTTPServer(":8080")
-	log.Fatal(srv.ListenAndServe())
+	dir := flag.String("dir", "data", "directory the logs are stored in")
+	httpAddr := flag.String("http-addr", ":8080", "address to serve the HTTP API on")
+	grpcAddr := flag.String("grpc-addr", ":8400", "address to serve the gRPC API on")
+	var c commitlog.Config
+	flag.Uint64Var(&c.Segment.MaxStoreBytes, "segment-bytes", 64<<20, "max size of a segment's store in the default log and topics")
+	flag.Uint64Var(&c.Segment.MaxIndexBytes, "index-bytes", 10<<20, "max size of a segment's index in the default log and topics")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, *dir, *httpAddr, *grpcAddr, c); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the logs in dir until ctx is done or either server fails.
+// The default log and topics' partitions are created with c, the
+// offsets and transaction logs are compacted so they keep the smaller
+// segments they default to.
+func run(ctx context.Context, dir, httpAddr, grpcAddr string, c commitlog.Config) error {
+	clog, err := openLog(path.Join(dir, "default"), c)
+	if err != nil {
+		return err
+	}
+	defer clog.Close()
+
+	topics, err := topic.NewRegistry(path.Join(dir, "topics"), topic.Config{Log: c})
+	if err != nil {
+		return err
+	}
+	defer topics.Close()
+
+	if err = os.MkdirAll(path.Join(dir, "offsets"), 0755); err != nil {
+		return err
+	}
+	offsets, err := commitlog.NewOffsets(path.Join(dir, "offsets"), commitlog.Config{})
+	if err != nil {
+		return err
+	}
+	defer offsets.Close()
+
+	if err = os.MkdirAll(path.Join(dir, "transactions"), 0755); err != nil {
+		return err
+	}
+	txns, err := txn.NewCoordinator(path.Join(dir, "transactions"), txn.Config{
+		Partition: server.TransactionPartitions(clog, topics),
+	})
+	if err != nil {
+		return err
+	}
+	defer txns.Close()
+
+	gsrv, err := server.NewGRPCServer(&server.Config{
+		CommitLog:    clog,
+		Topics:       topics,
+		Offsets:      offsets,
+		Groups:       group.NewCoordinator(group.Config{Partitions: topics.Partitions}),
+		Transactions: txns,
+	})
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return err
+	}
+	hsrv := server.NewHTTPServer(httpAddr, clog)
+
+	errc := make(chan error, 2)
+	go func() { errc <- gsrv.Serve(ln) }()
+	go func() { errc <- hsrv.ListenAndServe() }()
+	log.Printf("serving gRPC on %s and HTTP on %s", ln.Addr(), httpAddr)
+
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
+
+	// Stop both servers before the logs they use are closed.
+	hsrv.Shutdown(context.Background())
+	gsrv.GracefulStop()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// openLog opens the log in dir, creating it with c if it's new.
+func openLog(dir string, c commitlog.Config) (*commitlog.Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return commitlog.NewLog(dir, c)
 }